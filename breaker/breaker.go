@@ -0,0 +1,232 @@
+// Package breaker 单个节点的熔断器
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+)
+
+type State int
+
+const (
+	StateClosed   State = iota //正常
+	StateOpen                  //熔断，不再调度到该节点
+	StateHalfOpen              //半开，只放行少量探测请求
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+const buckets = 10 //统计窗口分成的桶数
+
+type bucket struct {
+	start   int64 //桶的开始时间，单位为桶时长
+	success int
+	failure int
+}
+
+// Breaker 熔断器：连续失败或窗口内错误率达到阈值时熔断，经过OpenTimeout后半开，
+// 放行HalfOpenRequests个请求，全部成功则恢复，任一失败则重新熔断。
+// nil的Breaker始终处于正常状态
+type Breaker struct {
+	cfg         config.CircuitBreaker
+	mutex       sync.Mutex
+	state       State
+	openedAt    time.Time
+	consecutive int //连续失败次数
+	window      [buckets]bucket
+	bucketSize  time.Duration
+	inFlight    int       //半开状态下已放行未返回的请求数
+	probedAt    time.Time //最近一次放行探测请求的时间
+	passed      int       //半开状态下成功的请求数
+}
+
+// New 按配置创建熔断器，cfg为nil时返回nil，即不熔断
+func New(cfg *config.CircuitBreaker) *Breaker {
+	if cfg == nil {
+		return nil
+	}
+	c := *cfg
+	if c.ConsecutiveFailures <= 0 && c.ErrorRate <= 0 {
+		c.ConsecutiveFailures = 5
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 10
+	}
+	if c.Window <= 0 {
+		c.Window = 10 * time.Second
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = 30 * time.Second
+	}
+	if c.HalfOpenRequests <= 0 {
+		c.HalfOpenRequests = 1
+	}
+	size := c.Window / buckets
+	if size <= 0 {
+		size = 1
+	}
+	return &Breaker{
+		cfg:        c,
+		bucketSize: size,
+	}
+}
+
+// State 当前状态，熔断时间已过时返回半开
+func (b *Breaker) State() State {
+	if b == nil {
+		return StateClosed
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refresh(time.Now())
+	return b.state
+}
+
+// Ready 是否可以发送请求，不占用半开状态的探测名额，供调度时判断节点是否可用
+func (b *Breaker) Ready() bool {
+	if b == nil {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refresh(time.Now())
+	switch b.state {
+	case StateClosed:
+		return true
+	case StateHalfOpen:
+		return b.inFlight < b.cfg.HalfOpenRequests
+	}
+	return false
+}
+
+// Acquire 节点被选中时调用，半开状态下占用一个探测名额
+func (b *Breaker) Acquire() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	b.refresh(now)
+	if b.state == StateHalfOpen {
+		b.inFlight++
+		b.probedAt = now
+	}
+}
+
+// Success 上报一次成功
+func (b *Breaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	b.refresh(now)
+	switch b.state {
+	case StateClosed:
+		b.consecutive = 0
+		b.bucket(now).success++
+	case StateHalfOpen:
+		if b.inFlight > 0 {
+			b.inFlight--
+		}
+		if b.passed++; b.passed >= b.cfg.HalfOpenRequests {
+			b.reset(StateClosed)
+		}
+	}
+}
+
+// Failure 上报一次失败
+func (b *Breaker) Failure() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	b.refresh(now)
+	switch b.state {
+	case StateClosed:
+		b.consecutive++
+		b.bucket(now).failure++
+		if b.tripped(now) {
+			b.open(now)
+		}
+	case StateHalfOpen:
+		b.open(now)
+	}
+}
+
+// refresh 熔断时间已过则进入半开；半开的探测请求超过OpenTimeout未返回时视为丢失，释放名额
+func (b *Breaker) refresh(now time.Time) {
+	switch b.state {
+	case StateOpen:
+		if now.Sub(b.openedAt) >= b.cfg.OpenTimeout {
+			b.reset(StateHalfOpen)
+		}
+	case StateHalfOpen:
+		if b.inFlight > 0 && now.Sub(b.probedAt) >= b.cfg.OpenTimeout {
+			b.inFlight = 0
+		}
+	}
+}
+
+func (b *Breaker) open(now time.Time) {
+	b.reset(StateOpen)
+	b.openedAt = now
+}
+
+func (b *Breaker) reset(state State) {
+	b.state = state
+	b.consecutive = 0
+	b.window = [buckets]bucket{}
+	b.inFlight = 0
+	b.passed = 0
+}
+
+func (b *Breaker) tripped(now time.Time) bool {
+	if b.cfg.ConsecutiveFailures > 0 && b.consecutive >= b.cfg.ConsecutiveFailures {
+		return true
+	}
+	if b.cfg.ErrorRate <= 0 {
+		return false
+	}
+	success, failure := b.sum(now)
+	total := success + failure
+	return total >= b.cfg.MinRequests && float64(failure)/float64(total) >= b.cfg.ErrorRate
+}
+
+// bucket 当前时间所在的桶，桶已过期时清空
+func (b *Breaker) bucket(now time.Time) *bucket {
+	start := now.UnixNano() / int64(b.bucketSize)
+	bk := &b.window[start%buckets]
+	if bk.start != start {
+		*bk = bucket{start: start}
+	}
+	return bk
+}
+
+// sum 统计窗口内的成功和失败次数
+func (b *Breaker) sum(now time.Time) (success, failure int) {
+	cur := now.UnixNano() / int64(b.bucketSize)
+	for _, bk := range b.window {
+		if cur-bk.start < buckets {
+			success += bk.success
+			failure += bk.failure
+		}
+	}
+	return
+}
@@ -0,0 +1,124 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+)
+
+const openTimeout = 20 * time.Millisecond
+
+func failures(b *Breaker, n int) {
+	for i := 0; i < n; i++ {
+		b.Failure()
+	}
+}
+
+func TestNil(t *testing.T) {
+	b := New(nil)
+	if b != nil {
+		t.Fatal("New(nil) should return nil")
+	}
+	b.Acquire()
+	failures(b, 10)
+	if !b.Ready() || b.State() != StateClosed {
+		t.Error("nil breaker should always be closed")
+	}
+}
+
+func TestConsecutiveFailures(t *testing.T) {
+	b := New(&config.CircuitBreaker{ConsecutiveFailures: 3, OpenTimeout: time.Hour})
+	failures(b, 2)
+	b.Success() //成功后重新计数
+	failures(b, 2)
+	if b.State() != StateClosed {
+		t.Fatalf("state = %s after non-consecutive failures, want closed", b.State())
+	}
+	b.Failure()
+	if b.State() != StateOpen || b.Ready() {
+		t.Errorf("state = %s ready = %v after 3 consecutive failures, want open", b.State(), b.Ready())
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	b := New(&config.CircuitBreaker{ErrorRate: 0.5, MinRequests: 4, Window: time.Hour, OpenTimeout: time.Hour})
+	failures(b, 3) //请求数不足MinRequests，不按错误率熔断
+	if b.State() != StateClosed {
+		t.Fatalf("state = %s below MinRequests, want closed", b.State())
+	}
+	for i := 0; i < 4; i++ {
+		b.Success()
+	}
+	b.Failure() //4/8
+	if b.State() != StateOpen {
+		t.Errorf("state = %s at error rate 0.5, want open", b.State())
+	}
+
+	b = New(&config.CircuitBreaker{ErrorRate: 0.5, MinRequests: 4, Window: 100 * time.Millisecond, OpenTimeout: time.Hour})
+	failures(b, 3)
+	time.Sleep(150 * time.Millisecond) //窗口外的统计不再计入
+	b.Success()
+	b.Failure()
+	if b.State() != StateClosed {
+		t.Errorf("state = %s, failures outside the window should not count", b.State())
+	}
+}
+
+func TestHalfOpen(t *testing.T) {
+	b := New(&config.CircuitBreaker{ConsecutiveFailures: 1, OpenTimeout: openTimeout, HalfOpenRequests: 2})
+	b.Failure()
+	if b.State() != StateOpen {
+		t.Fatalf("state = %s, want open", b.State())
+	}
+	time.Sleep(openTimeout)
+	if b.State() != StateHalfOpen || !b.Ready() {
+		t.Fatalf("state = %s ready = %v after OpenTimeout, want half-open", b.State(), b.Ready())
+	}
+	b.Acquire()
+	if !b.Ready() {
+		t.Fatal("one probe in flight of 2, should still be ready")
+	}
+	b.Acquire()
+	if b.Ready() {
+		t.Fatal("all probes in flight, Ready should not let more requests through")
+	}
+	b.Success()
+	if b.State() != StateHalfOpen {
+		t.Fatalf("state = %s after 1 of 2 probes succeeded, want half-open", b.State())
+	}
+	b.Success()
+	if b.State() != StateClosed {
+		t.Errorf("state = %s after all probes succeeded, want closed", b.State())
+	}
+
+	b.Failure()
+	time.Sleep(openTimeout)
+	b.Acquire()
+	b.Failure() //探测失败重新熔断
+	if b.State() != StateOpen || b.Ready() {
+		t.Errorf("state = %s after a failed probe, want open", b.State())
+	}
+}
+
+func TestLostProbe(t *testing.T) {
+	b := New(&config.CircuitBreaker{ConsecutiveFailures: 1, OpenTimeout: openTimeout})
+	b.Failure()
+	time.Sleep(openTimeout)
+	b.Acquire() //探测请求没有上报结果
+	if b.Ready() {
+		t.Fatal("probe in flight, should not be ready")
+	}
+	time.Sleep(openTimeout)
+	if b.State() != StateHalfOpen || !b.Ready() {
+		t.Errorf("state = %s ready = %v, a probe lost for OpenTimeout should be released", b.State(), b.Ready())
+	}
+}
+
+func TestStateString(t *testing.T) {
+	for s, want := range map[State]string{StateClosed: "closed", StateOpen: "open", StateHalfOpen: "half-open", State(9): "unknown"} {
+		if s.String() != want {
+			t.Errorf("%d.String() = %s, want %s", s, s.String(), want)
+		}
+	}
+}
@@ -10,23 +10,53 @@ type Config struct {
 	Endpoints   []string         `mapstructure:"endpoints" json:"endpoints" yaml:"endpoints"`
 	Scheme      string           `mapstructure:"scheme" json:"scheme" yaml:"scheme"`
 	Timeout     time.Duration    `mapstructure:"timeout" json:"timeout" yaml:"timeout"`
+	Token       string           `mapstructure:"token" json:"token" yaml:"token"`                //访问令牌(consul ACL token)
+	Datacenter  string           `mapstructure:"datacenter" json:"datacenter" yaml:"datacenter"` //数据中心(consul)
+	Partition   string           `mapstructure:"partition" json:"partition" yaml:"partition"`    //管理分区(consul enterprise)
+	TLS         *TLSConfig       `mapstructure:"tls" json:"tls" yaml:"tls"`                      //TLS配置
+	Region      string           `mapstructure:"region" json:"region" yaml:"region"`             //本服务所在地域，用于就近路由
+	Zone        string           `mapstructure:"zone" json:"zone" yaml:"zone"`                   //本服务所在可用区，用于就近路由
 	Registers   []*RegisterNode  `mapstructure:"registers" json:"registers" yaml:"registers"`
 	Discoveries []*DiscoveryNode `mapstructure:"discoveries" json:"discoveries" yaml:"discoveries"`
 }
 
+const (
+	CheckModeTTL = "ttl" //服务自身上报健康状态
+
+	StatusDraining    = "draining"    //摘流中，消费方不再调度到该节点
+	StatusMaintenance = "maintenance" //维护中，消费方不再调度到该节点
+	StatusUnhealthy   = "unhealthy"   //本地就绪检查未通过，消费方不再调度到该节点
+
+	MetaMaintenanceReason = "maintenance-reason" //维护原因，记录在节点元数据中
+	MetaUnhealthyReason   = "unhealthy-reason"   //就绪检查失败原因，记录在节点元数据中
+)
+
+type TLSConfig struct {
+	CAFile             string `mapstructure:"ca-file" json:"ca-file" yaml:"ca-file"`                                        //CA证书
+	CertFile           string `mapstructure:"cert-file" json:"cert-file" yaml:"cert-file"`                                  //客户端证书
+	KeyFile            string `mapstructure:"key-file" json:"key-file" yaml:"key-file"`                                     //客户端私钥
+	ServerName         string `mapstructure:"server-name" json:"server-name" yaml:"server-name"`                            //服务端证书域名
+	InsecureSkipVerify bool   `mapstructure:"insecure-skip-verify" json:"insecure-skip-verify" yaml:"insecure-skip-verify"` //跳过证书校验
+}
+
 type RegisterNode struct {
-	Namespace   string        `mapstructure:"namespace" json:"namespace" yaml:"namespace"`          //命名空间
-	Id          string        `mapstructure:"id" json:"id" yaml:"id"`                               //服务id
-	Name        string        `mapstructure:"name" json:"name" yaml:"name"`                         //服务名
-	Addr        string        `mapstructure:"addr" json:"addr" yaml:"addr"`                         //服务地址
-	Port        int           `mapstructure:"port" json:"port" yaml:"port"`                         //端口
-	Protocol    string        `mapstructure:"protocol" json:"protocol" yaml:"protocol"`             //协议
-	Weight      int           `mapstructure:"weight" json:"weight" yaml:"weight"`                   //权重
-	Interval    time.Duration `mapstructure:"interval" json:"interval" yaml:"interval"`             //检测间隔
-	Timeout     time.Duration `mapstructure:"timeout" json:"timeout" yaml:"timeout"`                //服务检测超时时间
-	HealthCheck string        `mapstructure:"health-check" json:"health-check" yaml:"health-check"` //健康检查地址
-	Tags        []string      `mapstructure:"tags" json:"tags" yaml:"tags"`                         //标签
-	FailLimit   int           `mapstructure:"fail-limit" json:"fail-limit" yaml:"fail-limit"`       //失败次数限制，到达失败次数就会被禁用
+	Namespace   string            `mapstructure:"namespace" json:"namespace" yaml:"namespace"`          //命名空间
+	Id          string            `mapstructure:"id" json:"id" yaml:"id"`                               //服务id
+	Name        string            `mapstructure:"name" json:"name" yaml:"name"`                         //服务名
+	Addr        string            `mapstructure:"addr" json:"addr" yaml:"addr"`                         //服务地址
+	Port        int               `mapstructure:"port" json:"port" yaml:"port"`                         //端口
+	Protocol    string            `mapstructure:"protocol" json:"protocol" yaml:"protocol"`             //协议
+	Weight      int               `mapstructure:"weight" json:"weight" yaml:"weight"`                   //权重，默认为1
+	Interval    time.Duration     `mapstructure:"interval" json:"interval" yaml:"interval"`             //检测间隔
+	Timeout     time.Duration     `mapstructure:"timeout" json:"timeout" yaml:"timeout"`                //服务检测超时时间
+	HealthCheck string            `mapstructure:"health-check" json:"health-check" yaml:"health-check"` //健康检查地址，http服务为空时默认为 http://addr:port/health/ready
+	CheckMode   string            `mapstructure:"check-mode" json:"check-mode" yaml:"check-mode"`       //健康检查方式，ttl由服务自身定时上报，默认由注册中心访问HealthCheck
+	Tags        []string          `mapstructure:"tags" json:"tags" yaml:"tags"`                         //标签
+	Metadata    map[string]string `mapstructure:"metadata" json:"metadata" yaml:"metadata"`             //元数据，如版本、git提交、接口能力等
+	Region      string            `mapstructure:"region" json:"region" yaml:"region"`                   //地域
+	Zone        string            `mapstructure:"zone" json:"zone" yaml:"zone"`                         //可用区
+	FailLimit   int               `mapstructure:"fail-limit" json:"fail-limit" yaml:"fail-limit"`       //失败次数限制，到达失败次数就会被禁用
+	Status      string            `mapstructure:"status" json:"status" yaml:"status"`                   //运行状态，如摘流中(draining)、维护中(maintenance)、不健康(unhealthy)，由客户端维护，无需配置
 }
 
 // func (e *RegisterNode) GetInterval() time.Duration {
@@ -58,11 +88,56 @@ type RegisterNode struct {
 // }
 
 type DiscoveryNode struct {
-	Enable              bool   `mapstructure:"enable" json:"enable" yaml:"enable"`                                           //启用发现
-	Namespace           string `mapstructure:"namespace" json:"namespace" yaml:"namespace"`                                  //命名空间
-	Name                string `mapstructure:"name" json:"name" yaml:"name"`                                                 //服务名
-	Tag                 string `mapstructure:"tag" json:"tag" yaml:"tag"`                                                    //标签
-	SchedulingAlgorithm string `mapstructure:"scheduling-algorithm" json:"scheduling-algorithm" yaml:"scheduling-algorithm"` //调度算法
-	FailLimit           int    `mapstructure:"fail-limit" json:"fail-limit" yaml:"fail-limit"`                               //已发现服务最大失败数
-	RetryTime           int    `mapstructure:"retry-time" json:"retry-time" yaml:"retry-time"`                               //重试时间间隔 秒
+	Enable              bool               `mapstructure:"enable" json:"enable" yaml:"enable"`                                           //启用发现
+	Namespace           string             `mapstructure:"namespace" json:"namespace" yaml:"namespace"`                                  //命名空间
+	Name                string             `mapstructure:"name" json:"name" yaml:"name"`                                                 //服务名
+	Tag                 string             `mapstructure:"tag" json:"tag" yaml:"tag"`                                                    //标签
+	Metadata            map[string]string  `mapstructure:"metadata" json:"metadata" yaml:"metadata"`                                     //元数据过滤，只发现元数据全部匹配的节点
+	Selector            string             `mapstructure:"selector" json:"selector" yaml:"selector"`                                     //选择表达式，如 env=prod,version in (v2,v3),!canary
+	SchedulingAlgorithm string             `mapstructure:"scheduling-algorithm" json:"scheduling-algorithm" yaml:"scheduling-algorithm"` //调度算法
+	FailLimit           int                `mapstructure:"fail-limit" json:"fail-limit" yaml:"fail-limit"`                               //已发现服务最大失败数
+	RetryTime           int                `mapstructure:"retry-time" json:"retry-time" yaml:"retry-time"`                               //被摘除节点的重试间隔 秒，到时探测成功后重新启用，连续被摘除时间隔翻倍，默认30秒
+	WaitTime            time.Duration      `mapstructure:"wait-time" json:"wait-time" yaml:"wait-time"`                                  //阻塞查询最长等待时间，默认使用Config.Timeout
+	MinInterval         time.Duration      `mapstructure:"min-interval" json:"min-interval" yaml:"min-interval"`                         //两次查询的最小间隔，防止注册中心频繁变化时请求过多，默认1秒
+	MaxBackoff          time.Duration      `mapstructure:"max-backoff" json:"max-backoff" yaml:"max-backoff"`                            //查询出错时的最大退避时间，默认1分钟
+	ZoneAware           bool               `mapstructure:"zone-aware" json:"zone-aware" yaml:"zone-aware"`                               //优先调用同可用区的节点
+	ZoneThreshold       float64            `mapstructure:"zone-threshold" json:"zone-threshold" yaml:"zone-threshold"`                   //同可用区可用节点比例低于该值时溢出到其他可用区，默认0即没有可用节点时才溢出
+	TrafficSplit        []*TrafficSubset   `mapstructure:"traffic-split" json:"traffic-split" yaml:"traffic-split"`                      //按百分比分配流量到不同子集，用于灰度发布
+	CircuitBreaker      *CircuitBreaker    `mapstructure:"circuit-breaker" json:"circuit-breaker" yaml:"circuit-breaker"`                //节点熔断配置，为空时不熔断
+	OutlierDetection    *OutlierDetection  `mapstructure:"outlier-detection" json:"outlier-detection" yaml:"outlier-detection"`          //异常节点检测配置，为空时不检测
+	HealthCheck         *ActiveHealthCheck `mapstructure:"health-check" json:"health-check" yaml:"health-check"`                         //主动健康检查配置，为空时不检查
+}
+
+type TrafficSubset struct {
+	Name     string `mapstructure:"name" json:"name" yaml:"name"`             //子集名称，如 v1、canary
+	Selector string `mapstructure:"selector" json:"selector" yaml:"selector"` //子集节点的选择表达式，如 version=v2
+	Percent  int    `mapstructure:"percent" json:"percent" yaml:"percent"`    //流量百分比
+}
+
+type CircuitBreaker struct {
+	ConsecutiveFailures int           `mapstructure:"consecutive-failures" json:"consecutive-failures" yaml:"consecutive-failures"` //连续失败次数达到该值时熔断，与error-rate都未设置时默认5
+	ErrorRate           float64       `mapstructure:"error-rate" json:"error-rate" yaml:"error-rate"`                               //统计窗口内错误率达到该值时熔断，取值0~1，0表示不按错误率熔断
+	MinRequests         int           `mapstructure:"min-requests" json:"min-requests" yaml:"min-requests"`                         //统计窗口内请求数达到该值才按错误率判断，默认10
+	Window              time.Duration `mapstructure:"window" json:"window" yaml:"window"`                                           //错误率统计窗口，默认10秒
+	OpenTimeout         time.Duration `mapstructure:"open-timeout" json:"open-timeout" yaml:"open-timeout"`                         //熔断多久后进入半开状态，默认30秒
+	HalfOpenRequests    int           `mapstructure:"half-open-requests" json:"half-open-requests" yaml:"half-open-requests"`       //半开状态允许的探测请求数，全部成功后恢复，默认1
+}
+
+type OutlierDetection struct {
+	Interval               time.Duration `mapstructure:"interval" json:"interval" yaml:"interval"`                                                    //检测间隔，默认10秒
+	BaseEjectionTime       time.Duration `mapstructure:"base-ejection-time" json:"base-ejection-time" yaml:"base-ejection-time"`                      //基础摘除时间，第n次被摘除时摘除n倍时间，默认30秒
+	MaxEjectionPercent     int           `mapstructure:"max-ejection-percent" json:"max-ejection-percent" yaml:"max-ejection-percent"`                //同时被摘除的节点最多占服务节点数的百分比，默认10，至少允许摘除1个节点
+	MinRequests            int           `mapstructure:"min-requests" json:"min-requests" yaml:"min-requests"`                                        //节点在一个检测间隔内的请求数达到该值才参与统计，默认5
+	MinHosts               int           `mapstructure:"min-hosts" json:"min-hosts" yaml:"min-hosts"`                                                 //参与统计的节点数达到该值才检测，默认3
+	SuccessRateStdevFactor float64       `mapstructure:"success-rate-stdev-factor" json:"success-rate-stdev-factor" yaml:"success-rate-stdev-factor"` //成功率低于 平均值-该系数*标准差 的节点为异常节点，默认1.9
+	LatencyFactor          float64       `mapstructure:"latency-factor" json:"latency-factor" yaml:"latency-factor"`                                  //平均耗时超过所有节点中位数的该倍数的节点为异常节点，0表示不按耗时检测
+}
+
+// ActiveHealthCheck 发现方主动检查已发现节点：HealthCheck为http地址时发送GET请求，grpc节点调用grpc健康检查，否则建立TCP连接
+type ActiveHealthCheck struct {
+	Interval           time.Duration `mapstructure:"interval" json:"interval" yaml:"interval"`                                  //检查间隔，默认10秒
+	Timeout            time.Duration `mapstructure:"timeout" json:"timeout" yaml:"timeout"`                                     //单次检查超时时间，默认3秒
+	Jitter             float64       `mapstructure:"jitter" json:"jitter" yaml:"jitter"`                                        //抖动比例，取值0~1，各节点的检查时间在间隔的该比例内随机分散，默认0.2
+	UnhealthyThreshold int           `mapstructure:"unhealthy-threshold" json:"unhealthy-threshold" yaml:"unhealthy-threshold"` //连续失败该次数后不再调度到节点，默认2
+	HealthyThreshold   int           `mapstructure:"healthy-threshold" json:"healthy-threshold" yaml:"healthy-threshold"`       //不健康节点连续成功该次数后恢复，默认1
 }
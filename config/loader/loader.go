@@ -0,0 +1,242 @@
+// Package loader 从yaml、json、toml文件和环境变量加载config.Config
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/baowk/dilu-rd/config"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix 覆盖配置的环境变量前缀。变量名由配置路径转换而来：键转大写、"-"换成"_"，
+// 层级和数组下标之间用"_"连接，例如
+//
+//	DILU_RD_DRIVER=consul
+//	DILU_RD_ENDPOINTS=10.0.0.1:8500,10.0.0.2:8500
+//	DILU_RD_TLS_CA_FILE=/etc/rd/ca.pem
+//	DILU_RD_REGISTERS_0_PORT=8080
+//	DILU_RD_REGISTERS_0_METADATA_VERSION=v2
+//
+// 数组可以通过下标追加元素。环境变量名无法区分大小写，覆盖map时与文件中已有的键不区分大小写匹配
+// ("-"与"_"视为相同)，匹配到时沿用文件中的键，如文件中的Version被DILU_RD_..._METADATA_VERSION覆盖后仍为Version；
+// 文件中没有的键转为小写
+const EnvPrefix = "DILU_RD"
+
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+// Load 读取配置文件，按扩展名识别格式(.yaml/.yml/.json/.toml)，再用环境变量覆盖
+func Load(path string) (*config.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format, err := formatOf(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse 解析指定格式的配置内容。
+// 字符串值中的${VAR}、${VAR:-default}会被替换为环境变量的值，然后用DILU_RD_*环境变量覆盖，
+// 时长可以写成"5s"、"1m30s"，写成数字时单位为纳秒。data为空时只从环境变量加载
+func Parse(data []byte, format string) (*config.Config, error) {
+	m := make(map[string]any)
+	var err error
+	switch strings.ToLower(format) {
+	case FormatYAML, "yml":
+		err = yaml.Unmarshal(data, &m)
+	case FormatJSON:
+		if len(data) > 0 {
+			err = json.Unmarshal(data, &m)
+		}
+	case FormatTOML:
+		err = toml.Unmarshal(data, &m)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m == nil { //空的yaml文档
+		m = make(map[string]any)
+	}
+	expand(m)
+	overlayEnv(m, reflect.TypeOf(config.Config{}), EnvPrefix, environ())
+
+	var cfg config.Config
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		Result:           &cfg,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := dec.Decode(m); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func formatOf(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".json":
+		return FormatJSON, nil
+	case ".toml":
+		return FormatTOML, nil
+	}
+	return "", fmt.Errorf("unsupported config file %s, must be .yaml, .yml, .json or .toml", path)
+}
+
+var placeholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expand 替换所有字符串值中的${VAR}，变量未设置时使用":-"后的默认值
+func expand(v any) any {
+	switch vv := v.(type) {
+	case string:
+		return placeholder.ReplaceAllStringFunc(vv, func(s string) string {
+			sub := placeholder.FindStringSubmatch(s)
+			if val, ok := os.LookupEnv(sub[1]); ok {
+				return val
+			}
+			return sub[3]
+		})
+	case map[string]any:
+		for k, e := range vv {
+			vv[k] = expand(e)
+		}
+	case []any:
+		for i, e := range vv {
+			vv[i] = expand(e)
+		}
+	}
+	return v
+}
+
+// environ 返回以EnvPrefix开头的环境变量
+func environ() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(k, EnvPrefix+"_") {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// overlayEnv 按结构体t的mapstructure标签，用环境变量覆盖m中对应的值
+func overlayEnv(m map[string]any, t reflect.Type, prefix string, env map[string]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, _, _ := strings.Cut(f.Tag.Get("mapstructure"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft.Kind() == reflect.Struct:
+			sub, ok := m[key].(map[string]any)
+			if !ok {
+				if !hasPrefix(env, name+"_") {
+					continue
+				}
+				sub = make(map[string]any)
+				m[key] = sub
+			}
+			overlayEnv(sub, ft, name, env)
+		case ft.Kind() == reflect.Slice && isStruct(ft.Elem()):
+			list, _ := m[key].([]any)
+			for j := 0; ; j++ {
+				p := name + "_" + strconv.Itoa(j)
+				if j >= len(list) {
+					if !hasPrefix(env, p+"_") {
+						break
+					}
+					list = append(list, make(map[string]any))
+				}
+				sub, ok := list[j].(map[string]any)
+				if !ok {
+					continue
+				}
+				overlayEnv(sub, elem(ft.Elem()), p, env)
+			}
+			if len(list) > 0 {
+				m[key] = list
+			}
+		case ft.Kind() == reflect.Map:
+			sub, ok := m[key].(map[string]any)
+			for k, v := range env {
+				if mk, found := strings.CutPrefix(k, name+"_"); found && mk != "" {
+					if !ok {
+						sub = make(map[string]any)
+						m[key] = sub
+						ok = true
+					}
+					sub[mapKey(sub, mk)] = v
+				}
+			}
+		default:
+			if v, ok := env[name]; ok {
+				m[key] = v
+			}
+		}
+	}
+}
+
+// mapKey 环境变量中的键在m中对应的键，m中没有时为小写
+func mapKey(m map[string]any, envKey string) string {
+	for k := range m {
+		if strings.EqualFold(strings.ReplaceAll(k, "-", "_"), envKey) {
+			return k
+		}
+	}
+	return strings.ToLower(envKey)
+}
+
+func hasPrefix(env map[string]string, prefix string) bool {
+	for k := range env {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func elem(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+func isStruct(t reflect.Type) bool {
+	return elem(t).Kind() == reflect.Struct
+}
@@ -0,0 +1,206 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+)
+
+const yamlConfig = `
+driver: consul
+endpoints: [10.0.0.1:8500, 10.0.0.2:8500]
+timeout: 5s
+registers:
+  - name: api
+    addr: 10.0.0.10
+    port: 8080
+    protocol: http
+    interval: 1m30s
+    metadata:
+      Version: v1
+discoveries:
+  - enable: true
+    name: api
+    health-check:
+      interval: 2s
+`
+
+const jsonConfig = `{
+  "driver": "consul",
+  "endpoints": ["10.0.0.1:8500", "10.0.0.2:8500"],
+  "timeout": "5s",
+  "registers": [{"name": "api", "addr": "10.0.0.10", "port": 8080, "protocol": "http", "interval": "1m30s", "metadata": {"Version": "v1"}}],
+  "discoveries": [{"enable": true, "name": "api", "health-check": {"interval": "2s"}}]
+}`
+
+const tomlConfig = `
+driver = "consul"
+endpoints = ["10.0.0.1:8500", "10.0.0.2:8500"]
+timeout = "5s"
+
+[[registers]]
+name = "api"
+addr = "10.0.0.10"
+port = 8080
+protocol = "http"
+interval = "1m30s"
+[registers.metadata]
+Version = "v1"
+
+[[discoveries]]
+enable = true
+name = "api"
+[discoveries.health-check]
+interval = "2s"
+`
+
+func expected() *config.Config {
+	return &config.Config{
+		Driver:    "consul",
+		Endpoints: []string{"10.0.0.1:8500", "10.0.0.2:8500"},
+		Timeout:   5 * time.Second,
+		Registers: []*config.RegisterNode{{
+			Name: "api", Addr: "10.0.0.10", Port: 8080, Protocol: "http",
+			Interval: 90 * time.Second,
+			Metadata: map[string]string{"Version": "v1"},
+		}},
+		Discoveries: []*config.DiscoveryNode{{
+			Enable: true, Name: "api",
+			HealthCheck: &config.ActiveHealthCheck{Interval: 2 * time.Second},
+		}},
+	}
+}
+
+func TestParseFormats(t *testing.T) {
+	for format, data := range map[string]string{FormatYAML: yamlConfig, FormatJSON: jsonConfig, FormatTOML: tomlConfig} {
+		cfg, err := Parse([]byte(data), format)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if want := expected(); !reflect.DeepEqual(cfg, want) {
+			t.Errorf("%s: got %+v, want %+v", format, cfg, want)
+		}
+	}
+	if _, err := Parse([]byte(yamlConfig), "ini"); err == nil {
+		t.Error("unsupported format should fail")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{"rd.yml": yamlConfig, "rd.json": jsonConfig, "rd.toml": tomlConfig} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if want := expected(); !reflect.DeepEqual(cfg, want) {
+			t.Errorf("%s: got %+v, want %+v", name, cfg, want)
+		}
+	}
+	path := filepath.Join(dir, "rd.ini")
+	os.WriteFile(path, []byte(yamlConfig), 0o644)
+	if _, err := Load(path); err == nil {
+		t.Error("unsupported extension should fail")
+	}
+}
+
+func TestExpand(t *testing.T) {
+	t.Setenv("RD_TEST_HOST", "10.0.0.5")
+	t.Setenv("RD_TEST_EMPTY", "")
+	cfg, err := Parse([]byte(`
+endpoints: ["${RD_TEST_HOST}:8500", "${RD_TEST_UNSET:-127.0.0.1}:8500"]
+token: "${RD_TEST_EMPTY:-default}"
+registers:
+  - name: api
+    addr: ${RD_TEST_HOST}
+    metadata:
+      zone: ${RD_TEST_UNSET:-}
+      note: ${RD_TEST_UNSET}
+`), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.5:8500", "127.0.0.1:8500"}; !reflect.DeepEqual(cfg.Endpoints, want) {
+		t.Errorf("endpoints = %v, want %v", cfg.Endpoints, want)
+	}
+	if cfg.Token != "" {
+		t.Errorf("token = %q, a set but empty variable should not use the default", cfg.Token)
+	}
+	rs := cfg.Registers[0]
+	if rs.Addr != "10.0.0.5" || rs.Metadata["zone"] != "" || rs.Metadata["note"] != "" {
+		t.Errorf("register = %+v", rs)
+	}
+}
+
+func TestEnvOverlay(t *testing.T) {
+	for k, v := range map[string]string{
+		"DILU_RD_DRIVER":                               "etcd",
+		"DILU_RD_ENDPOINTS":                            "10.0.0.7:2379,10.0.0.8:2379",
+		"DILU_RD_TIMEOUT":                              "3s",
+		"DILU_RD_TLS_CA_FILE":                          "/etc/rd/ca.pem",
+		"DILU_RD_REGISTERS_0_PORT":                     "9090",
+		"DILU_RD_REGISTERS_0_METADATA_VERSION":         "v2",
+		"DILU_RD_REGISTERS_0_METADATA_GIT_COMMIT":      "abc123",
+		"DILU_RD_REGISTERS_1_NAME":                     "rpc",
+		"DILU_RD_REGISTERS_1_INTERVAL":                 "10s",
+		"DILU_RD_DISCOVERIES_0_HEALTH_CHECK_INTERVAL":  "4s",
+		"DILU_RD_DISCOVERIES_0_METADATA_ENV":           "prod",
+		"DILU_RD_DISCOVERIES_0_CIRCUIT_BREAKER_WINDOW": "1m",
+	} {
+		t.Setenv(k, v)
+	}
+	cfg, err := Parse([]byte(yamlConfig), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Driver != "etcd" || cfg.Timeout != 3*time.Second || cfg.TLS == nil || cfg.TLS.CAFile != "/etc/rd/ca.pem" {
+		t.Errorf("driver = %s timeout = %s tls = %+v", cfg.Driver, cfg.Timeout, cfg.TLS)
+	}
+	if want := []string{"10.0.0.7:2379", "10.0.0.8:2379"}; !reflect.DeepEqual(cfg.Endpoints, want) {
+		t.Errorf("endpoints = %v, want %v", cfg.Endpoints, want)
+	}
+	if len(cfg.Registers) != 2 {
+		t.Fatalf("registers = %d, want 2 with one appended by index", len(cfg.Registers))
+	}
+	api := cfg.Registers[0]
+	if api.Port != 9090 || api.Name != "api" || api.Interval != 90*time.Second {
+		t.Errorf("registers[0] = %+v, want port overridden and the rest kept", api)
+	}
+	//文件中的Version被覆盖且不会出现重复的version，新增的键为小写
+	if want := map[string]string{"Version": "v2", "git_commit": "abc123"}; !reflect.DeepEqual(api.Metadata, want) {
+		t.Errorf("registers[0].metadata = %v, want %v", api.Metadata, want)
+	}
+	if rpc := cfg.Registers[1]; rpc.Name != "rpc" || rpc.Interval != 10*time.Second {
+		t.Errorf("registers[1] = %+v", rpc)
+	}
+	ds := cfg.Discoveries[0]
+	if ds.HealthCheck.Interval != 4*time.Second || ds.Metadata["env"] != "prod" || ds.CircuitBreaker == nil || ds.CircuitBreaker.Window != time.Minute {
+		t.Errorf("discoveries[0] = %+v", ds)
+	}
+}
+
+func TestEnvOnly(t *testing.T) {
+	t.Setenv("DILU_RD_DRIVER", "consul")
+	t.Setenv("DILU_RD_REGISTERS_0_NAME", "api")
+	t.Setenv("DILU_RD_REGISTERS_0_PORT", "8080")
+	for _, format := range []string{FormatYAML, FormatJSON, FormatTOML} {
+		cfg, err := Parse(nil, format)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if cfg.Driver != "consul" || len(cfg.Registers) != 1 || cfg.Registers[0].Port != 8080 {
+			t.Errorf("%s: %+v", format, cfg)
+		}
+	}
+}
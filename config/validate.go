@@ -0,0 +1,318 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baowk/dilu-rd/selector"
+)
+
+const (
+	DefaultFailLimit = 3                //默认失败次数限制
+	DefaultWeight    = 1                //默认权重，与consul agent未设置权重时一致
+	DefaultInterval  = 5 * time.Second  //默认检测间隔
+	DefaultTimeout   = 10 * time.Second //默认检测超时时间
+
+	DefaultHealthPath = "/health" //rd.HealthHandler的路径，其下 /live 为存活检查、/ready 为就绪检查
+)
+
+// FieldError 单个配置项的错误，Field为配置路径，如 registers[1].port
+type FieldError struct {
+	Field string
+	Msg   string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+// ValidationError 配置校验发现的全部错误
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap 使errors.As可以取出其中的*FieldError
+func (e ValidationError) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, fe := range e {
+		errs[i] = fe
+	}
+	return errs
+}
+
+func (e *ValidationError) add(field, format string, args ...any) {
+	*e = append(*e, &FieldError{Field: field, Msg: fmt.Sprintf(format, args...)})
+}
+
+// WithDefaults 返回填充了默认值的副本，不修改cfg。
+// 注册节点的Id默认为addr:port，Weight默认为1，Region、Zone默认使用Config中的值，
+// http服务未配置健康检查地址时默认为rd.HealthHandler的就绪检查地址 http://addr:port/health/ready
+func WithDefaults(cfg *Config) *Config {
+	c := *cfg
+	c.Registers = make([]*RegisterNode, len(cfg.Registers))
+	for i, rs := range cfg.Registers {
+		if rs == nil {
+			continue
+		}
+		c.Registers[i] = RegisterWithDefaults(rs, c.Region, c.Zone)
+	}
+	c.Discoveries = make([]*DiscoveryNode, len(cfg.Discoveries))
+	for i, ds := range cfg.Discoveries {
+		if ds == nil {
+			continue
+		}
+		n := *ds
+		if n.FailLimit <= 0 {
+			n.FailLimit = DefaultFailLimit
+		}
+		c.Discoveries[i] = &n
+	}
+	return &c
+}
+
+// RegisterWithDefaults 返回填充了默认值的注册节点副本，不修改rs，默认值同WithDefaults，Region、Zone为空时使用region、zone
+func RegisterWithDefaults(rs *RegisterNode, region, zone string) *RegisterNode {
+	n := *rs
+	if n.Id == "" {
+		n.Id = fmt.Sprintf("%s:%d", n.Addr, n.Port)
+	}
+	if n.Region == "" {
+		n.Region = region
+	}
+	if n.Zone == "" {
+		n.Zone = zone
+	}
+	if n.Weight == 0 { //未设置权重的节点在weight调度算法下不会被选中
+		n.Weight = DefaultWeight
+	}
+	if n.FailLimit <= 0 {
+		n.FailLimit = DefaultFailLimit
+	}
+	if n.Interval <= 0 {
+		n.Interval = DefaultInterval
+	}
+	if n.Timeout <= 0 {
+		n.Timeout = DefaultTimeout
+	}
+	if n.HealthCheck == "" && n.Protocol == "http" && n.CheckMode != CheckModeTTL {
+		n.HealthCheck = fmt.Sprintf("http://%s%s/ready", net.JoinHostPort(n.Addr, strconv.Itoa(n.Port)), DefaultHealthPath)
+	}
+	return &n
+}
+
+// ValidateRegister 校验单个注册节点，用于不经过配置直接注册的节点，rs需已填充默认值
+func ValidateRegister(rs *RegisterNode) error {
+	var errs ValidationError
+	validateRegister(&errs, "register", rs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Validate 校验配置，返回包含全部错误的ValidationError，没有错误时返回nil。
+// 未设置的项按WithDefaults的默认值校验
+func Validate(cfg *Config) error {
+	if cfg == nil {
+		return ValidationError{{Field: "config", Msg: "is nil"}}
+	}
+	c := WithDefaults(cfg)
+	var errs ValidationError
+	if c.Driver != "etcd" && c.Driver != "consul" {
+		errs.add("driver", "unsupported driver %q, must be etcd or consul", c.Driver)
+	}
+	if len(c.Endpoints) == 0 {
+		errs.add("endpoints", "is empty")
+	}
+	for i, ep := range c.Endpoints {
+		if strings.TrimSpace(ep) == "" {
+			errs.add(fmt.Sprintf("endpoints[%d]", i), "is empty")
+		}
+	}
+	if c.Timeout < 0 {
+		errs.add("timeout", "must not be negative")
+	}
+	if c.TLS != nil && (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs.add("tls", "cert-file and key-file must be set together")
+	}
+	ids := make(map[string]int)
+	for i, rs := range c.Registers {
+		validateRegister(&errs, fmt.Sprintf("registers[%d]", i), rs)
+		if rs == nil {
+			continue
+		}
+		if j, ok := ids[rs.Id]; ok {
+			errs.add(fmt.Sprintf("registers[%d].id", i), "duplicate id %q, also used by registers[%d]", rs.Id, j)
+		} else {
+			ids[rs.Id] = i
+		}
+	}
+	names := make(map[string]int)
+	for i, ds := range c.Discoveries {
+		validateDiscovery(&errs, fmt.Sprintf("discoveries[%d]", i), ds)
+		if ds == nil || ds.Name == "" {
+			continue
+		}
+		if j, ok := names[ds.Name]; ok {
+			errs.add(fmt.Sprintf("discoveries[%d].name", i), "duplicate name %q, also used by discoveries[%d]", ds.Name, j)
+		} else {
+			names[ds.Name] = i
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateRegister(errs *ValidationError, path string, rs *RegisterNode) {
+	if rs == nil {
+		errs.add(path, "is nil")
+		return
+	}
+	if rs.Name == "" {
+		errs.add(path+".name", "is empty")
+	}
+	if rs.Addr == "" {
+		errs.add(path+".addr", "is empty")
+	}
+	if rs.Port <= 0 || rs.Port > 65535 {
+		errs.add(path+".port", "out of range")
+	}
+	if rs.Protocol != "http" && rs.Protocol != "grpc" {
+		errs.add(path+".protocol", "unsupported protocol %q, must be http or grpc", rs.Protocol)
+	}
+	if rs.Weight < 0 {
+		errs.add(path+".weight", "must not be negative")
+	}
+	if rs.CheckMode != "" && rs.CheckMode != CheckModeTTL {
+		errs.add(path+".check-mode", "unsupported check mode %q", rs.CheckMode)
+	}
+	// 续约或上报间隔不小于超时时间时，节点会在两次检测之间过期
+	if rs.Timeout <= rs.Interval {
+		errs.add(path+".timeout", "%s must be greater than interval %s", rs.Timeout, rs.Interval)
+	}
+}
+
+func validateDiscovery(errs *ValidationError, path string, ds *DiscoveryNode) {
+	if ds == nil {
+		errs.add(path, "is nil")
+		return
+	}
+	if ds.Name == "" {
+		errs.add(path+".name", "is empty")
+	}
+	switch ds.SchedulingAlgorithm {
+	case "", "random", "robin", "weight":
+	default:
+		errs.add(path+".scheduling-algorithm", "unsupported algorithm %q", ds.SchedulingAlgorithm)
+	}
+	if ds.RetryTime < 0 {
+		errs.add(path+".retry-time", "must not be negative")
+	}
+	if ds.WaitTime < 0 {
+		errs.add(path+".wait-time", "must not be negative")
+	}
+	if ds.MinInterval < 0 {
+		errs.add(path+".min-interval", "must not be negative")
+	}
+	if ds.MaxBackoff < 0 {
+		errs.add(path+".max-backoff", "must not be negative")
+	}
+	if ds.ZoneThreshold < 0 || ds.ZoneThreshold > 1 {
+		errs.add(path+".zone-threshold", "must be between 0 and 1")
+	}
+	if _, err := selector.Parse(ds.Selector); err != nil {
+		errs.add(path+".selector", "%v", err)
+	}
+	if cb := ds.CircuitBreaker; cb != nil {
+		p := path + ".circuit-breaker"
+		if cb.ConsecutiveFailures < 0 {
+			errs.add(p+".consecutive-failures", "must not be negative")
+		}
+		if cb.ErrorRate < 0 || cb.ErrorRate > 1 {
+			errs.add(p+".error-rate", "must be between 0 and 1")
+		}
+		if cb.MinRequests < 0 {
+			errs.add(p+".min-requests", "must not be negative")
+		}
+		if cb.Window < 0 {
+			errs.add(p+".window", "must not be negative")
+		}
+		if cb.OpenTimeout < 0 {
+			errs.add(p+".open-timeout", "must not be negative")
+		}
+		if cb.HalfOpenRequests < 0 {
+			errs.add(p+".half-open-requests", "must not be negative")
+		}
+	}
+	if od := ds.OutlierDetection; od != nil {
+		p := path + ".outlier-detection"
+		if od.Interval < 0 {
+			errs.add(p+".interval", "must not be negative")
+		}
+		if od.BaseEjectionTime < 0 {
+			errs.add(p+".base-ejection-time", "must not be negative")
+		}
+		if od.MaxEjectionPercent < 0 || od.MaxEjectionPercent > 100 {
+			errs.add(p+".max-ejection-percent", "must be between 0 and 100")
+		}
+		if od.MinRequests < 0 {
+			errs.add(p+".min-requests", "must not be negative")
+		}
+		if od.MinHosts < 0 {
+			errs.add(p+".min-hosts", "must not be negative")
+		}
+		if od.SuccessRateStdevFactor < 0 {
+			errs.add(p+".success-rate-stdev-factor", "must not be negative")
+		}
+		if od.LatencyFactor < 0 {
+			errs.add(p+".latency-factor", "must not be negative")
+		}
+	}
+	if hc := ds.HealthCheck; hc != nil {
+		p := path + ".health-check"
+		if hc.Interval < 0 {
+			errs.add(p+".interval", "must not be negative")
+		}
+		if hc.Timeout < 0 {
+			errs.add(p+".timeout", "must not be negative")
+		}
+		if hc.Jitter < 0 || hc.Jitter > 1 {
+			errs.add(p+".jitter", "must be between 0 and 1")
+		}
+		if hc.UnhealthyThreshold < 0 {
+			errs.add(p+".unhealthy-threshold", "must not be negative")
+		}
+		if hc.HealthyThreshold < 0 {
+			errs.add(p+".healthy-threshold", "must not be negative")
+		}
+	}
+	total := 0
+	for i, ts := range ds.TrafficSplit {
+		p := fmt.Sprintf("%s.traffic-split[%d]", path, i)
+		if ts == nil {
+			errs.add(p, "is nil")
+			continue
+		}
+		if ts.Percent < 0 || ts.Percent > 100 {
+			errs.add(p+".percent", "out of range")
+		}
+		if _, err := selector.Parse(ts.Selector); err != nil {
+			errs.add(p+".selector", "%v", err)
+		}
+		total += ts.Percent
+	}
+	if total > 100 {
+		errs.add(path+".traffic-split", "percent sum %d exceeds 100", total)
+	}
+}
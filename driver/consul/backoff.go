@@ -0,0 +1,76 @@
+package consul
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/baowk/dilu-rd/driver"
+)
+
+// backoff 查询出错时的指数退避，带随机抖动避免所有客户端同时重试
+type backoff struct {
+	max     time.Duration
+	attempt int
+	r       *rand.Rand
+}
+
+func newBackoff(max time.Duration) *backoff {
+	if max <= 0 {
+		max = time.Minute
+	}
+	return &backoff{
+		max: max,
+		r:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next 下一次重试前的等待时间，在 [d/2, d) 之间随机，d 从1秒开始翻倍直到max
+func (b *backoff) next() time.Duration {
+	d := time.Second << b.attempt
+	if d <= 0 || d > b.max {
+		d = b.max
+	} else {
+		b.attempt++
+	}
+	return d/2 + time.Duration(b.r.Int63n(int64(d/2)+1))
+}
+
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// limiter 限制两次查询之间的最小间隔
+type limiter struct {
+	interval time.Duration
+	last     time.Time
+}
+
+func newLimiter(interval time.Duration) *limiter {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &limiter{interval: interval}
+}
+
+// wait 距上次查询不足间隔时等待，ctx结束时返回false
+func (l *limiter) wait(ctx context.Context) bool {
+	if d := l.interval - time.Since(l.last); d > 0 {
+		if !driver.Sleep(ctx, d) {
+			return false
+		}
+	}
+	l.last = time.Now()
+	return ctx.Err() == nil
+}
+
+// nextIndex 阻塞查询的下一个等待索引，索引回退时(如consul快照恢复)从0开始，索引必须大于0
+func nextIndex(last, index uint64) uint64 {
+	if index < last {
+		return 0
+	}
+	if index == 0 {
+		return 1
+	}
+	return index
+}
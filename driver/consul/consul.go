@@ -1,55 +1,162 @@
 package consul
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"sync"
-	"time"
 
 	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/driver"
 	"github.com/baowk/dilu-rd/models"
-	"github.com/baowk/dilu-rd/scheduling"
 
 	"github.com/hashicorp/consul/api"
 )
 
 type ConsulClient struct {
-	client             *api.Client
-	rwmutex            sync.RWMutex
-	registered         []*config.RegisterNode
-	discovered         map[string][]*models.ServiceNode //已发现的服务
-	schedulingHandlers map[string]scheduling.SchedulingHandler
+	*driver.Discovery
+	client        *api.Client
+	transport     *http.Transport //当前agent客户端的连接池，切换agent或关闭时释放
+	agentMutex    sync.RWMutex
+	failoverMutex sync.Mutex //切换agent时持有，避免多个协程同时探测
+	cfg           api.Config //原始配置，切换agent时复用
+	endpoints     []string   //所有agent地址
+	cur           int        //当前使用的agent下标
+	monitorOnce   sync.Once
+	healthFunc    HealthFunc               //ttl模式的本地健康检测
+	registered    map[string]*registration //已注册的服务，key为服务id，由agentMutex保护
+	token         string                   //ACL令牌
+	datacenter    string                   //数据中心
+	partition     string                   //管理分区
 }
 
-func NewClient(cfg *api.Config) (*ConsulClient, error) {
+// registration 一个注册节点及其后台协程(ttl上报)
+type registration struct {
+	node   *config.RegisterNode
+	cancel context.CancelFunc
+}
+
+func NewClient(cfg *api.Config, endpoints []string) (*ConsulClient, error) {
+	if len(endpoints) == 0 {
+		if cfg.Address == "" {
+			return nil, errors.New("consul endpoints is empty")
+		}
+		endpoints = []string{cfg.Address}
+	}
+	origin := *cfg
+	cfg.Address = endpoints[0]
 	client, err := api.NewClient(cfg)
 	if err != nil {
 		return nil, err
 	}
 	return &ConsulClient{
-		client:             client,
-		rwmutex:            sync.RWMutex{},
-		discovered:         make(map[string][]*models.ServiceNode),
-		registered:         make([]*config.RegisterNode, 0),
-		schedulingHandlers: make(map[string]scheduling.SchedulingHandler),
+		Discovery:  driver.NewDiscovery(),
+		client:     client,
+		transport:  cfg.Transport,
+		cfg:        origin,
+		endpoints:  endpoints,
+		registered: make(map[string]*registration),
+		token:      cfg.Token,
+		datacenter: cfg.Datacenter,
+		partition:  cfg.Partition,
 	}, nil
 }
 
+// queryOptions 每次请求携带的ACL令牌、数据中心和分区
+func (c *ConsulClient) queryOptions(namespace string) *api.QueryOptions {
+	return &api.QueryOptions{
+		Namespace:  namespace,
+		Datacenter: c.datacenter,
+		Partition:  c.partition,
+		Token:      c.token,
+	}
+}
+
 func (c *ConsulClient) Register(s *config.RegisterNode) error {
-	meta := map[string]string{
-		"protocol": string(s.Protocol),
+	return c.RegisterContext(context.Background(), s)
+}
+
+// RegisterContext 向当前agent注册服务，ctx只作用于本次注册，ttl上报和agent探测在Deregister或Close时停止
+func (c *ConsulClient) RegisterContext(ctx context.Context, s *config.RegisterNode) error {
+	if c.Closed() {
+		return driver.ErrClosed
+	}
+	client := c.agent()
+	err := c.register(ctx, client, s)
+	if err != nil && ctx.Err() == nil && isUnreachable(err) && c.failover(client) {
+		//failover 已经把之前注册的服务迁移到新的agent，这里只需注册当前服务
+		err = c.register(ctx, c.agent(), s)
+	}
+	if err != nil {
+		slog.Error("register", "err", err)
+		return err
+	}
+	rctx, cancel := context.WithCancel(c.Context())
+	c.agentMutex.Lock()
+	if old, ok := c.registered[s.Id]; ok {
+		old.cancel()
+	}
+	c.registered[s.Id] = &registration{node: s, cancel: cancel}
+	c.agentMutex.Unlock()
+	c.monitorOnce.Do(func() {
+		c.Go(func(ctx context.Context) {
+			c.monitor(ctx, s.Interval)
+		})
+	})
+	if s.CheckMode == config.CheckModeTTL {
+		return c.GoContext(rctx, func(ctx context.Context) {
+			c.updateTTL(ctx, s)
+		})
+	}
+	return nil
+}
+
+func (c *ConsulClient) Update(s *config.RegisterNode) error {
+	return c.UpdateContext(context.Background(), s)
+}
+
+// UpdateContext 更新已注册节点的标签、权重、元数据等，agent按id原地更新，服务名不能修改
+func (c *ConsulClient) UpdateContext(ctx context.Context, s *config.RegisterNode) error {
+	c.agentMutex.RLock()
+	r, ok := c.registered[s.Id]
+	c.agentMutex.RUnlock()
+	if !ok {
+		return driver.ErrNotRegistered
+	}
+	if r.node.Name != s.Name {
+		return fmt.Errorf("update %s: name cannot be changed from %s to %s", s.Id, r.node.Name, s.Name)
 	}
+	return c.RegisterContext(ctx, s)
+}
+
+// register 向指定agent注册服务，agent上的注册只在本地有效
+func (c *ConsulClient) register(ctx context.Context, client *api.Client, s *config.RegisterNode) error {
 	r := &api.AgentServiceRegistration{
 		Namespace: s.Namespace,
+		Partition: c.partition,
 		ID:        s.Id,
 		Name:      s.Name,
 		Port:      s.Port,
 		Address:   s.Addr,
 		Tags:      s.Tags,
-		Meta:      meta,
+		Meta:      metadata(s),
+	}
+	if s.Weight > 0 {
+		r.Weights = &api.AgentWeights{
+			Passing: s.Weight,
+			Warning: warningWeight(s.Weight),
+		}
 	}
 	var check *api.AgentServiceCheck
-	if s.HealthCheck != "" {
+	if s.CheckMode == config.CheckModeTTL {
+		r.Check = &api.AgentServiceCheck{
+			CheckID:                        ttlCheckId(s),
+			TTL:                            (s.Interval * 3).String(), //超过3个上报周期未上报即为不健康
+			DeregisterCriticalServiceAfter: (s.Timeout * 3).String(),
+		}
+	} else if s.HealthCheck != "" {
 		check = &api.AgentServiceCheck{
 			Timeout:                        s.Timeout.String(),
 			Interval:                       s.Interval.String(),
@@ -63,130 +170,227 @@ func (c *ConsulClient) Register(s *config.RegisterNode) error {
 		r.Check = check
 	}
 
-	err := c.client.Agent().ServiceRegister(r)
-	if err != nil {
-		slog.Error("register", "err", err)
+	if err := client.Agent().ServiceRegisterOpts(r, api.ServiceRegisterOpts{Token: c.token}.WithContext(ctx)); err != nil {
 		return err
 	}
-	c.registered = append(c.registered, s)
+	if s.Status == config.StatusMaintenance { //切换agent后新agent上也需要进入维护
+		return client.Agent().EnableServiceMaintenanceOpts(s.Id, s.Metadata[config.MetaMaintenanceReason], c.queryOptions(s.Namespace).WithContext(ctx))
+	}
 	return nil
 }
 
 func (c *ConsulClient) Deregister() {
+	c.DeregisterContext(context.Background())
+}
+
+// DeregisterContext 停止ttl上报并从agent注销所有已注册的服务
+func (c *ConsulClient) DeregisterContext(ctx context.Context) error {
+	client := c.agent()
+	c.agentMutex.Lock()
+	registered := c.registered
+	c.registered = make(map[string]*registration)
+	c.agentMutex.Unlock()
+	var errs []error
+	for _, r := range registered {
+		r.cancel()
+		if err := client.Agent().ServiceDeregisterOpts(r.node.Id, c.queryOptions(r.node.Namespace).WithContext(ctx)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RegisteredNode 返回已注册节点的副本
+func (c *ConsulClient) RegisteredNode(id string) (*config.RegisterNode, bool) {
+	c.agentMutex.RLock()
+	defer c.agentMutex.RUnlock()
+	r, ok := c.registered[id]
+	if !ok {
+		return nil, false
+	}
+	n := *r.node
+	return &n, true
+}
+
+// RegisteredNodes 返回所有已注册节点的副本，按id排序
+func (c *ConsulClient) RegisteredNodes() []*config.RegisterNode {
+	c.agentMutex.RLock()
+	nodes := make([]*config.RegisterNode, 0, len(c.registered))
 	for _, r := range c.registered {
-		c.client.Agent().ServiceDeregister(r.Id)
+		n := *r.node
+		nodes = append(nodes, &n)
 	}
+	c.agentMutex.RUnlock()
+	driver.SortNodes(nodes)
+	return nodes
+}
+
+func (c *ConsulClient) SetMaintenance(id string, enable bool, reason string) error {
+	return c.SetMaintenanceContext(context.Background(), id, enable, reason)
+}
+
+// SetMaintenanceContext 使用agent的服务维护模式设置或取消维护，同时更新元数据中的状态，
+// 维护中的服务健康检查为critical，消费方不再发现该节点
+func (c *ConsulClient) SetMaintenanceContext(ctx context.Context, id string, enable bool, reason string) error {
+	node, ok := c.RegisteredNode(id)
+	if !ok {
+		return driver.ErrNotRegistered
+	}
+	driver.SetMaintenance(node, enable, reason)
+	if err := c.RegisterContext(ctx, node); err != nil { //进入维护由register完成
+		return err
+	}
+	if enable {
+		return nil
+	}
+	return c.agent().Agent().DisableServiceMaintenanceOpts(id, c.queryOptions(node.Namespace).WithContext(ctx))
+}
+
+func (c *ConsulClient) DeregisterNode(id string) error {
+	return c.DeregisterNodeContext(context.Background(), id)
+}
+
+// DeregisterNodeContext 只注销指定id的节点，其他节点不受影响
+func (c *ConsulClient) DeregisterNodeContext(ctx context.Context, id string) error {
+	c.agentMutex.Lock()
+	r, ok := c.registered[id]
+	delete(c.registered, id)
+	c.agentMutex.Unlock()
+	if !ok {
+		return driver.ErrNotRegistered
+	}
+	r.cancel()
+	return c.agent().Agent().ServiceDeregisterOpts(id, c.queryOptions(r.node.Namespace).WithContext(ctx))
 }
 
 func (c *ConsulClient) Watch(s *config.DiscoveryNode) error {
+	return c.WatchContext(context.Background(), s)
+}
+
+// WatchContext 同步查询一次服务节点后在后台用阻塞查询监听变化，ctx只作用于首次查询，监听在Close时停止
+func (c *ConsulClient) WatchContext(ctx context.Context, s *config.DiscoveryNode) error {
+	if c.Closed() {
+		return driver.ErrClosed
+	}
+	wctx, err := c.AddService(s)
+	if err != nil {
+		return err
+	}
 	var lastIndex uint64 = 0
-	c.schedulingHandlers[s.Name] = scheduling.GetHandler(s.SchedulingAlgorithm)
-	go func(s *config.DiscoveryNode) {
-		for {
-			entries, qmeta, err := c.client.Health().Service(s.Name, s.Tag, false, &api.QueryOptions{
-				Namespace: s.Namespace,
-				WaitIndex: lastIndex,
-			})
-			if err != nil {
-				slog.Error("watch", "err", err)
-				time.Sleep(time.Second * 1)
+	opts := c.queryOptions(s.Namespace).WithContext(ctx)
+	if entries, qmeta, err := c.agent().Health().Service(s.Name, s.Tag, false, opts); err != nil {
+		slog.Error("watch", "err", err, "agent", c.ActiveEndpoint())
+	} else {
+		lastIndex = nextIndex(0, qmeta.LastIndex)
+		c.syncServiceNodes(s, entries)
+	}
+	return c.GoContext(wctx, func(ctx context.Context) {
+		c.watch(ctx, s, lastIndex)
+	})
+}
+
+func (c *ConsulClient) watch(ctx context.Context, s *config.DiscoveryNode, lastIndex uint64) {
+	bo := newBackoff(s.MaxBackoff)
+	limiter := newLimiter(s.MinInterval)
+	for limiter.wait(ctx) {
+		opts := c.queryOptions(s.Namespace)
+		opts.WaitIndex = lastIndex
+		opts.WaitTime = s.WaitTime
+		client := c.agent()
+		entries, qmeta, err := client.Health().Service(s.Name, s.Tag, false, opts.WithContext(ctx))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("watch", "err", err, "agent", c.ActiveEndpoint())
+			lastIndex = 0
+			if isUnreachable(err) && c.failover(client) {
 				continue
 			}
-			lastIndex = qmeta.LastIndex
-			slog.Debug("watch", "entries", entries, "qmeta", qmeta)
-			for _, entry := range entries {
-				status := entry.Checks.AggregatedStatus()
-				slog.Debug("watch", "-------status--", status, "entry.Service.ID--------", entry.Service.ID)
-				switch status {
-				case api.HealthPassing:
-					c.putServiceNode(s, entry)
-				//case api.HealthMaint, api.HealthCritical, api.HealthWarning:
-				default:
-					c.delServiceNode(s, entry)
-				}
+			if !driver.Sleep(ctx, bo.next()) {
+				return
 			}
-			time.Sleep(time.Second * time.Duration(s.RetryTime))
+			continue
 		}
-	}(s)
-	return nil
+		bo.reset()
+		lastIndex = nextIndex(lastIndex, qmeta.LastIndex)
+		slog.Debug("watch", "entries", entries, "qmeta", qmeta)
+		c.syncServiceNodes(s, entries)
+	}
+}
+
+// Close 停止所有后台协程，注销已注册的服务，关闭已发现节点的连接并释放与agent的连接
+func (c *ConsulClient) Close(ctx context.Context) error {
+	c.Stop()
+	err := c.DeregisterContext(ctx)
+	if werr := c.Wait(ctx); werr != nil {
+		err = errors.Join(err, werr)
+	}
+	c.agentMutex.RLock()
+	if c.transport != nil {
+		c.transport.CloseIdleConnections()
+	}
+	c.agentMutex.RUnlock()
+	return err
 }
 
-func (c *ConsulClient) GetService(name string, clientIp string) (*models.ServiceNode, error) {
-	c.rwmutex.RLock()
-	defer c.rwmutex.RUnlock()
-	if rs, ok := c.discovered[name]; ok && len(rs) > 0 {
-		if sh, ok := c.schedulingHandlers[name]; ok {
-			return sh.GetServiceNode(rs, name), nil
+// syncServiceNodes 阻塞查询的结果即为服务的全量状态，与已发现的节点做差异比较：
+// 结果中不存在或不健康的节点被移除，地址变化的节点重建，其余节点原地更新。
+// warning状态的节点保留，使用consul中配置的warning权重
+func (c *ConsulClient) syncServiceNodes(s *config.DiscoveryNode, entries []*api.ServiceEntry) {
+	healthy := make(map[string]*api.ServiceEntry, len(entries))
+	for _, entry := range entries {
+		status := entry.Checks.AggregatedStatus()
+		slog.Debug("watch", "status", status, "id", entry.Service.ID)
+		switch status {
+		case api.HealthPassing, api.HealthWarning:
+			healthy[entry.Service.ID] = entry
 		}
 	}
-	return nil, errors.New("no service")
-}
-
-func (c *ConsulClient) putServiceNode(s *config.DiscoveryNode, entry *api.ServiceEntry) {
-	c.rwmutex.Lock()
-	defer c.rwmutex.Unlock()
-	if vs, ok := c.discovered[s.Name]; ok {
-		found := false
-		for _, v := range vs {
-			if v.Id == entry.Service.ID {
-				slog.Debug("watch", "update---", entry.Service.ID)
-				found = true
-				v.Addr = entry.Service.Address
-				v.Port = entry.Service.Port
-				v.Protocol = entry.Service.Meta["protocol"]
-				v.Namespace = entry.Service.Namespace
-				v.Tags = entry.Service.Tags
-				v.SetEnable(true)
-				v.ClearFailCnt()
-				break
-			}
+	for id, entry := range healthy {
+		if !models.MatchMetadata(entry.Service.Meta, s.Metadata) {
+			delete(healthy, id)
 		}
-		if !found {
-			slog.Debug("watch", "add reset---", entry.Service.ID)
-			ds := c.entryToServiceNode(entry, s)
-			vs = append(vs, ds)
-			c.discovered[s.Name] = vs
+	}
+	c.UpdateNodes(s.Name, func(vs []*models.ServiceNode) []*models.ServiceNode {
+		return c.diffServiceNodes(s, vs, entries, healthy)
+	})
+}
+
+func (c *ConsulClient) diffServiceNodes(s *config.DiscoveryNode, vs []*models.ServiceNode, entries []*api.ServiceEntry, healthy map[string]*api.ServiceEntry) []*models.ServiceNode {
+	nodes := make([]*models.ServiceNode, 0, len(healthy))
+	kept := make(map[string]bool, len(vs))
+	for _, v := range vs {
+		entry, ok := healthy[v.Id]
+		if !ok || v.Addr != entry.Service.Address || v.Port != entry.Service.Port {
+			slog.Debug("watch", "del", v.Id)
+			v.Close()
+			continue
 		}
-	} else {
-		slog.Debug("watch", "add 1---", entry.Service.ID)
-		ds := c.entryToServiceNode(entry, s)
-		c.discovered[s.Name] = []*models.ServiceNode{ds}
-	}
-}
-
-func (c *ConsulClient) delServiceNode(s *config.DiscoveryNode, entry *api.ServiceEntry) {
-	c.rwmutex.Lock()
-	defer c.rwmutex.Unlock()
-	slog.Debug("watch", "entry.Service.ID-----del---", entry.Service.ID)
-	if vs, ok := c.discovered[s.Name]; ok {
-		for i, v := range vs {
-			if v.Id == entry.Service.ID {
-				v.Close()
-				vs = append(vs[:i], vs[i+1:]...)
-				break
-			}
+		slog.Debug("watch", "update", v.Id)
+		nodes = append(nodes, applyEntry(v, entry)) //被摘除的节点由恢复协程探测后重新启用
+		kept[v.Id] = true
+	}
+	for _, entry := range entries {
+		if _, ok := healthy[entry.Service.ID]; ok && !kept[entry.Service.ID] {
+			slog.Debug("watch", "add", entry.Service.ID)
+			nodes = append(nodes, c.entryToServiceNode(entry, s))
+			kept[entry.Service.ID] = true
 		}
-		c.discovered[s.Name] = vs
-	} else {
-		slog.Debug("not found")
 	}
+	return nodes
 }
 
 func (c *ConsulClient) entryToServiceNode(entry *api.ServiceEntry, s *config.DiscoveryNode) *models.ServiceNode {
-	r := config.RegisterNode{
+	rn, weight := entryNode(config.RegisterNode{
 		Id:        entry.Service.ID,
-		Namespace: entry.Service.Namespace,
 		Name:      entry.Service.Service,
-		Tags:      entry.Service.Tags,
 		Addr:      entry.Service.Address,
 		Port:      entry.Service.Port,
-		Protocol:  entry.Service.Meta["protocol"],
 		FailLimit: s.FailLimit,
-	}
-	n := models.ServiceNode{
-		RegisterNode: r,
-	}
+	}, entry)
+	n := &models.ServiceNode{RegisterNode: rn, Weight: weight}
 	n.SetEnable(true)
 
-	return &n
+	return n
 }
@@ -0,0 +1,155 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/models"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeHealth 本地的consul健康查询替身，支持阻塞查询：请求的index不小于当前index时等待变化或超时
+type fakeHealth struct {
+	mutex   sync.Mutex
+	index   uint64
+	entries []*api.ServiceEntry
+	changed chan struct{}
+}
+
+func newFakeHealth(entries ...*api.ServiceEntry) (*fakeHealth, *httptest.Server) {
+	f := &fakeHealth{index: 1, entries: entries, changed: make(chan struct{})}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/v1/health/service/") {
+			w.Write([]byte("{}"))
+			return
+		}
+		wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+		f.mutex.Lock()
+		if wait > 0 && wait >= f.index {
+			changed := f.changed
+			f.mutex.Unlock()
+			select {
+			case <-changed:
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+				return
+			}
+			f.mutex.Lock()
+		}
+		index, body := f.index, f.entries
+		f.mutex.Unlock()
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+		json.NewEncoder(w).Encode(body)
+	}))
+	return f, srv
+}
+
+func (f *fakeHealth) set(entries ...*api.ServiceEntry) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.entries = entries
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func entry(id, addr string, port int, status string) *api.ServiceEntry {
+	return &api.ServiceEntry{
+		Service: &api.AgentService{
+			ID:      id,
+			Service: "svc",
+			Address: addr,
+			Port:    port,
+			Weights: api.AgentWeights{Passing: 10, Warning: 1},
+		},
+		Checks: api.HealthChecks{{CheckID: "service:" + id, Status: status}},
+	}
+}
+
+// discovered 当前已发现的节点，按id索引
+func discovered(c *ConsulClient, name string) map[string]*models.ServiceNode {
+	rs := make(map[string]*models.ServiceNode)
+	c.UpdateNodes(name, func(vs []*models.ServiceNode) []*models.ServiceNode {
+		for _, v := range vs {
+			rs[v.Id] = v
+		}
+		return vs
+	})
+	return rs
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchDiff(t *testing.T) {
+	f, srv := newFakeHealth(
+		entry("a", "10.0.0.1", 80, api.HealthPassing),
+		entry("b", "10.0.0.2", 80, api.HealthPassing),
+		entry("c", "10.0.0.3", 80, api.HealthPassing),
+		entry("x", "10.0.0.9", 80, api.HealthCritical),
+	)
+	defer srv.Close()
+	c, err := NewClient(&api.Config{Scheme: "http"}, []string{strings.TrimPrefix(srv.URL, "http://")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	if err := c.Watch(&config.DiscoveryNode{Enable: true, Name: "svc", FailLimit: 3, MinInterval: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	before := discovered(c, "svc")
+	if len(before) != 3 || before["x"] != nil {
+		t.Fatalf("discovered %v, want a b c", before)
+	}
+
+	f.set(
+		entry("a", "10.0.0.1", 80, api.HealthWarning),  //变为warning，保留
+		entry("b", "10.0.0.20", 80, api.HealthPassing), //地址变化，重建
+		//c 消失，移除
+		entry("d", "10.0.0.4", 80, api.HealthPassing), //新增
+	)
+	var after map[string]*models.ServiceNode
+	waitFor(t, "diff applied", func() bool {
+		after = discovered(c, "svc")
+		return after["d"] != nil
+	})
+
+	if len(after) != 3 || after["c"] != nil {
+		t.Fatalf("discovered %v, want a b d", after)
+	}
+	if a := after["a"]; a == before["a"] || a.Weight != 1 || !a.Available() || before["a"].Weight != 10 {
+		t.Errorf("warning node a: renewed=%v weight=%d available=%v, want a new node with warning weight", a != before["a"], a.Weight, a.Available())
+	}
+	if b := after["b"]; b == before["b"] || b.Addr != "10.0.0.20" {
+		t.Errorf("node b not rebuilt: same=%v addr=%s", b == before["b"], b.Addr)
+	}
+	if before["b"].Enable() || before["c"].Enable() || !before["a"].Enable() {
+		t.Error("removed nodes should be closed")
+	}
+
+	f.set(entry("a", "10.0.0.1", 80, api.HealthPassing))
+	waitFor(t, "a back to passing", func() bool {
+		after = discovered(c, "svc")
+		return len(after) == 1
+	})
+	if a := after["a"]; a.Weight != 10 || !a.Available() {
+		t.Errorf("node a: weight=%d available=%v, want passing weight", a.Weight, a.Available())
+	}
+}
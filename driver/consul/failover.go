@@ -0,0 +1,126 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/driver"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// agent 当前使用的agent客户端
+func (c *ConsulClient) agent() *api.Client {
+	c.agentMutex.RLock()
+	defer c.agentMutex.RUnlock()
+	return c.client
+}
+
+// ActiveEndpoint 当前使用的agent地址
+func (c *ConsulClient) ActiveEndpoint() string {
+	c.agentMutex.RLock()
+	defer c.agentMutex.RUnlock()
+	return c.endpoints[c.cur]
+}
+
+const pingTimeout = 3 * time.Second //探测agent是否可达的超时时间
+
+// ping 探测agent是否可达，agent返回HTTP错误(如ACL拒绝)也视为可达
+func (c *ConsulClient) ping(ctx context.Context, client *api.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	_, err := client.Agent().ServicesWithFilterOpts("", c.queryOptions("").WithContext(ctx))
+	if err != nil && isUnreachable(err) {
+		return err
+	}
+	return nil
+}
+
+// failover 当前agent不可达时依次尝试其余agent，切换成功后把本地注册的服务重新注册到新agent。
+// failed 为调用方出错时使用的客户端，若已被其他协程切换则直接返回true。
+// 探测在锁外进行，不可达的agent不会阻塞其他操作
+func (c *ConsulClient) failover(failed *api.Client) bool {
+	c.failoverMutex.Lock() //同一时间只有一个协程探测
+	defer c.failoverMutex.Unlock()
+	c.agentMutex.RLock()
+	if c.client != failed {
+		c.agentMutex.RUnlock()
+		return true
+	}
+	cur := c.cur
+	c.agentMutex.RUnlock()
+
+	var client *api.Client
+	var transport *http.Transport
+	idx := cur
+	for i := 1; i <= len(c.endpoints); i++ {
+		idx = (cur + i) % len(c.endpoints)
+		cfg := c.cfg
+		cfg.Address = c.endpoints[idx]
+		candidate, err := api.NewClient(&cfg)
+		if err != nil {
+			slog.Error("failover", "agent", cfg.Address, "err", err)
+			continue
+		}
+		if err := c.ping(c.Context(), candidate); err != nil {
+			slog.Warn("failover", "agent", cfg.Address, "err", err)
+			if cfg.Transport != nil {
+				cfg.Transport.CloseIdleConnections()
+			}
+			continue
+		}
+		client, transport = candidate, cfg.Transport
+		break
+	}
+	if client == nil {
+		slog.Error("failover", "err", "no reachable consul agent")
+		return false
+	}
+
+	c.agentMutex.Lock()
+	if c.transport != nil {
+		c.transport.CloseIdleConnections()
+	}
+	c.client = client
+	c.transport = transport
+	c.cur = idx
+	registered := make([]*config.RegisterNode, 0, len(c.registered))
+	for _, r := range c.registered {
+		registered = append(registered, r.node)
+	}
+	active := c.endpoints[idx]
+	c.agentMutex.Unlock()
+
+	slog.Warn("failover", "active", active)
+	for _, s := range registered {
+		if err := c.register(c.Context(), client, s); err != nil {
+			slog.Error("failover register", "id", s.Id, "err", err)
+		}
+	}
+	return true
+}
+
+// monitor 定期探测当前agent，不可达时触发切换，保证只注册不发现的进程也能切换agent
+func (c *ConsulClient) monitor(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Second * 5
+	}
+	for driver.Sleep(ctx, interval) {
+		client := c.agent()
+		if err := c.ping(ctx, client); err != nil && ctx.Err() == nil { //关闭时的取消不触发切换
+			slog.Warn("monitor", "agent", c.ActiveEndpoint(), "err", err)
+			c.failover(client)
+		}
+	}
+}
+
+// isUnreachable 是否为连接类错误(超时、拒绝连接等)，HTTP状态错误不触发切换
+func isUnreachable(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne)
+}
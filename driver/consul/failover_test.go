@@ -0,0 +1,95 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeAgent 本地的consul agent替身，记录注册到该agent的服务
+type fakeAgent struct {
+	*httptest.Server
+	mutex sync.Mutex
+	regs  map[string]string //服务id到服务名
+}
+
+func newFakeAgent() *fakeAgent {
+	a := &fakeAgent{regs: make(map[string]string)}
+	a.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/agent/service/register" {
+			var reg api.AgentServiceRegistration
+			json.NewDecoder(r.Body).Decode(&reg)
+			a.mutex.Lock()
+			a.regs[reg.ID] = reg.Name
+			a.mutex.Unlock()
+		}
+		w.Write([]byte("{}"))
+	}))
+	return a
+}
+
+func (a *fakeAgent) addr() string {
+	return strings.TrimPrefix(a.URL, "http://")
+}
+
+func (a *fakeAgent) registered() map[string]string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	rs := make(map[string]string, len(a.regs))
+	for k, v := range a.regs {
+		rs[k] = v
+	}
+	return rs
+}
+
+func TestFailover(t *testing.T) {
+	first, second := newFakeAgent(), newFakeAgent()
+	defer second.Close()
+	c, err := NewClient(&api.Config{Scheme: "http"}, []string{first.addr(), second.addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	for _, s := range []*config.RegisterNode{
+		{Id: "api-1", Name: "api", Addr: "127.0.0.1", Port: 8080, Protocol: "http", HealthCheck: "http://127.0.0.1:8080/health", Interval: 20 * time.Millisecond, Timeout: time.Second},
+		{Id: "rpc-1", Name: "rpc", Addr: "127.0.0.1", Port: 8081, Protocol: "grpc", HealthCheck: "127.0.0.1:8081/Health", Interval: 20 * time.Millisecond, Timeout: time.Second},
+	} {
+		if err := c.Register(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := c.ActiveEndpoint(); got != first.addr() {
+		t.Fatalf("active = %s, want the first agent %s", got, first.addr())
+	}
+	if got := first.registered(); len(got) != 2 {
+		t.Fatalf("first agent registered %v, want 2 services", got)
+	}
+
+	first.Close() //agent停止，监控协程探测失败后切换
+	waitFor(t, "switch to the second agent", func() bool {
+		return c.ActiveEndpoint() == second.addr()
+	})
+	waitFor(t, "services registered on the second agent", func() bool {
+		return len(second.registered()) == 2
+	})
+	if got := second.registered(); got["api-1"] != "api" || got["rpc-1"] != "rpc" {
+		t.Errorf("second agent registered %v, want api-1 and rpc-1", got)
+	}
+
+	s := &config.RegisterNode{Id: "api-2", Name: "api", Addr: "127.0.0.1", Port: 8082, Protocol: "http", HealthCheck: "http://127.0.0.1:8082/health", Interval: time.Second, Timeout: 2 * time.Second}
+	if err := c.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	if got := second.registered(); got["api-2"] != "api" {
+		t.Errorf("registration after failover went to %v, want the second agent", got)
+	}
+}
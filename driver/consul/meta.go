@@ -0,0 +1,80 @@
+package consul
+
+import (
+	"reflect"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/models"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// 注册节点中consul没有原生字段的属性，通过Meta传递
+const (
+	metaProtocol    = "protocol"
+	metaHealthCheck = "health-check"
+	metaCheckMode   = "check-mode"
+	metaRegion      = "region"
+	metaZone        = "zone"
+	metaStatus      = "status"
+)
+
+// metadata 合并用户元数据与内部属性，内部属性的键优先
+func metadata(s *config.RegisterNode) map[string]string {
+	meta := make(map[string]string, len(s.Metadata)+6)
+	for k, v := range s.Metadata {
+		meta[k] = v
+	}
+	meta[metaProtocol] = s.Protocol
+	meta[metaHealthCheck] = s.HealthCheck
+	meta[metaCheckMode] = s.CheckMode
+	meta[metaRegion] = s.Region
+	meta[metaZone] = s.Zone
+	if s.Status != "" {
+		meta[metaStatus] = s.Status
+	}
+	return meta
+}
+
+// warningWeight warning状态下的权重，为正常权重的1/10，最小为1
+func warningWeight(weight int) int {
+	if w := weight / 10; w > 0 {
+		return w
+	}
+	return 1
+}
+
+// applyEntry 返回写入consul服务信息后的已发现节点，当前权重按聚合健康状态取Passing或Warning权重。
+// 节点可能已返回给调用方，有变化时返回沿用运行状态的新节点，没有变化时返回n
+func applyEntry(n *models.ServiceNode, entry *api.ServiceEntry) *models.ServiceNode {
+	rn, weight := entryNode(n.RegisterNode, entry)
+	if n.Weight == weight && reflect.DeepEqual(n.RegisterNode, rn) {
+		return n
+	}
+	return n.Renew(rn, weight)
+}
+
+// entryNode 返回写入consul服务信息后的注册节点和当前权重
+func entryNode(n config.RegisterNode, entry *api.ServiceEntry) (config.RegisterNode, int) {
+	n.Namespace = entry.Service.Namespace
+	n.Tags = entry.Service.Tags
+	n.Protocol = entry.Service.Meta[metaProtocol]
+	n.HealthCheck = entry.Service.Meta[metaHealthCheck]
+	n.CheckMode = entry.Service.Meta[metaCheckMode]
+	n.Region = entry.Service.Meta[metaRegion]
+	n.Zone = entry.Service.Meta[metaZone]
+	n.Status = entry.Service.Meta[metaStatus]
+	n.Metadata = make(map[string]string, len(entry.Service.Meta))
+	for k, v := range entry.Service.Meta {
+		switch k {
+		case metaProtocol, metaHealthCheck, metaCheckMode, metaRegion, metaZone, metaStatus:
+		default:
+			n.Metadata[k] = v
+		}
+	}
+	n.Weight = entry.Service.Weights.Passing
+	if entry.Checks.AggregatedStatus() == api.HealthWarning {
+		return n, entry.Service.Weights.Warning
+	}
+	return n, entry.Service.Weights.Passing
+}
@@ -0,0 +1,56 @@
+package consul
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/driver"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// HealthFunc ttl模式下的本地健康检测，status 为 api.HealthPassing、api.HealthWarning 或 api.HealthCritical，output 为检测说明
+type HealthFunc func(s *config.RegisterNode) (status string, output string)
+
+// SetHealthFunc 设置ttl模式的本地健康检测，未设置时只要进程存活即上报passing
+func (c *ConsulClient) SetHealthFunc(fn HealthFunc) {
+	c.agentMutex.Lock()
+	defer c.agentMutex.Unlock()
+	c.healthFunc = fn
+}
+
+func ttlCheckId(s *config.RegisterNode) string {
+	return "service:" + s.Id
+}
+
+// updateTTL 每个检测间隔向agent上报一次健康状态，注册中心无需能访问到服务
+func (c *ConsulClient) updateTTL(ctx context.Context, s *config.RegisterNode) {
+	for {
+		c.agentMutex.RLock()
+		fn := c.healthFunc
+		c.agentMutex.RUnlock()
+		status, output := api.HealthPassing, ""
+		if s.Status == config.StatusUnhealthy { //本地就绪检查未通过
+			status, output = api.HealthCritical, s.Metadata[config.MetaUnhealthyReason]
+		} else if fn != nil {
+			status, output = fn(s)
+		}
+		client := c.agent()
+		err := client.Agent().UpdateTTLOpts(ttlCheckId(s), output, status, c.queryOptions(s.Namespace).WithContext(ctx))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("update ttl", "id", s.Id, "err", err)
+			if isUnreachable(err) {
+				c.failover(client)
+			} else if err := c.register(ctx, client, s); err != nil { //agent重启等原因丢失检测时重新注册
+				slog.Error("update ttl register", "id", s.Id, "err", err)
+			}
+		}
+		if !driver.Sleep(ctx, s.Interval) {
+			return
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/baowk/dilu-rd/breaker"
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/models"
+	"github.com/baowk/dilu-rd/routing"
+	"github.com/baowk/dilu-rd/scheduling"
+	"github.com/baowk/dilu-rd/selector"
+)
+
+var (
+	ErrNoService     = errors.New("no service")
+	ErrClosed        = errors.New("client is closed")
+	ErrNotRegistered = errors.New("node is not registered")
+)
+
+// Discovery 各驱动共用的部分：已发现服务的节点与调度，以及后台协程的生命周期
+type Discovery struct {
+	rwmutex            sync.RWMutex
+	discovered         map[string][]*models.ServiceNode //已发现的服务
+	schedulingHandlers map[string]scheduling.SchedulingHandler
+	watchers           map[string]context.CancelFunc    //正在监听的服务，用于单独停止监听
+	services           map[string]*config.DiscoveryNode //正在监听的服务的发现配置
+	region             string                           //本服务所在地域
+	zone               string                           //本服务所在可用区
+
+	lifeMutex sync.Mutex
+	ctx       context.Context //Close时取消，所有后台协程随之退出
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func NewDiscovery() *Discovery {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Discovery{
+		discovered:         make(map[string][]*models.ServiceNode),
+		schedulingHandlers: make(map[string]scheduling.SchedulingHandler),
+		watchers:           make(map[string]context.CancelFunc),
+		services:           make(map[string]*config.DiscoveryNode),
+		ctx:                ctx,
+		cancel:             cancel,
+	}
+}
+
+// SetLocality 设置本服务所在的地域和可用区，需在Watch之前调用
+func (d *Discovery) SetLocality(region, zone string) {
+	d.region = region
+	d.zone = zone
+}
+
+// AddService 按发现配置创建服务的调度器，返回监听协程使用的ctx，驱动在开始监听前调用。
+// 服务已在监听时停止原来的监听并替换调度器，已发现的节点保留。
+// 同时启动被摘除节点的恢复协程，随监听一起停止
+func (d *Discovery) AddService(s *config.DiscoveryNode) (context.Context, error) {
+	sh, err := scheduling.NewHandler(s, d.region, d.zone)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(d.ctx)
+	d.rwmutex.Lock()
+	if stop, ok := d.watchers[s.Name]; ok {
+		stop()
+	}
+	d.watchers[s.Name] = cancel
+	d.schedulingHandlers[s.Name] = sh
+	d.services[s.Name] = s
+	for _, n := range d.discovered[s.Name] { //熔断配置可能变化
+		n.SetBreaker(breaker.New(s.CircuitBreaker))
+	}
+	d.rwmutex.Unlock()
+	if err := d.GoContext(ctx, func(ctx context.Context) {
+		d.recovery(ctx, s)
+	}); err != nil {
+		return nil, err
+	}
+	if s.OutlierDetection != nil {
+		if err := d.GoContext(ctx, func(ctx context.Context) {
+			d.detectOutliers(ctx, s)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if s.HealthCheck != nil {
+		if err := d.GoContext(ctx, func(ctx context.Context) {
+			d.activeCheck(ctx, s)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+// Unwatch 停止监听服务，并移除该服务已发现的节点
+func (d *Discovery) Unwatch(name string) error {
+	d.rwmutex.Lock()
+	defer d.rwmutex.Unlock()
+	stop, ok := d.watchers[name]
+	if !ok {
+		return ErrNoService
+	}
+	stop()
+	for _, n := range d.discovered[name] {
+		n.Close()
+	}
+	delete(d.watchers, name)
+	delete(d.services, name)
+	delete(d.schedulingHandlers, name)
+	delete(d.discovered, name)
+	return nil
+}
+
+// UpdateNodes 在写锁内用fn的返回值替换服务的节点列表，被移除的节点由fn负责关闭，新节点按发现配置设置熔断器。
+// 服务已停止监听时不做任何修改
+func (d *Discovery) UpdateNodes(name string, fn func(nodes []*models.ServiceNode) []*models.ServiceNode) {
+	d.rwmutex.Lock()
+	defer d.rwmutex.Unlock()
+	s, ok := d.services[name]
+	if !ok {
+		return
+	}
+	old := make(map[*models.ServiceNode]bool, len(d.discovered[name]))
+	for _, n := range d.discovered[name] {
+		old[n] = true
+	}
+	nodes := fn(d.discovered[name])
+	for _, n := range nodes {
+		if !old[n] {
+			n.SetBreaker(breaker.New(s.CircuitBreaker))
+		}
+	}
+	d.discovered[name] = nodes
+}
+
+func (d *Discovery) GetService(name string, clientIp string) (*models.ServiceNode, error) {
+	return d.getService(name, nil, nil)
+}
+
+// GetServiceWithContext 优先选择与ctx中路由提示(见routing.WithHints)匹配的节点，没有匹配的可用节点时忽略提示
+func (d *Discovery) GetServiceWithContext(ctx context.Context, name string, clientIp string) (*models.ServiceNode, error) {
+	return d.getService(name, nil, selector.FromMap(routing.FromContext(ctx)))
+}
+
+// GetServiceWithSelector 按选择表达式过滤后再调度，表达式语法见selector.Parse
+func (d *Discovery) GetServiceWithSelector(name string, clientIp string, expr string) (*models.ServiceNode, error) {
+	return d.GetServiceWithSelectorContext(context.Background(), name, clientIp, expr)
+}
+
+// GetServiceWithSelectorContext 同时使用选择表达式和ctx中的路由提示
+func (d *Discovery) GetServiceWithSelectorContext(ctx context.Context, name string, clientIp string, expr string) (*models.ServiceNode, error) {
+	sel, err := selector.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return d.getService(name, sel, selector.FromMap(routing.FromContext(ctx)))
+}
+
+// SetTrafficSplit 运行时调整已发现服务的流量分配比例
+func (d *Discovery) SetTrafficSplit(name string, split []*config.TrafficSubset) error {
+	d.rwmutex.RLock()
+	sh, ok := d.schedulingHandlers[name]
+	d.rwmutex.RUnlock()
+	if !ok {
+		return ErrNoService
+	}
+	if ts, ok := sh.(scheduling.TrafficSplitter); ok {
+		return ts.SetTrafficSplit(split)
+	}
+	return errors.New("traffic split not supported")
+}
+
+// getService sel为必须满足的条件，prefer为优先满足的条件
+func (d *Discovery) getService(name string, sel, prefer selector.Selector) (*models.ServiceNode, error) {
+	d.rwmutex.RLock()
+	defer d.rwmutex.RUnlock()
+	if rs, ok := d.discovered[name]; ok && len(rs) > 0 {
+		if sh, ok := d.schedulingHandlers[name]; ok {
+			nodes := selector.Filter(sel, rs)
+			var n *models.ServiceNode
+			if len(prefer) > 0 {
+				n = sh.GetServiceNode(selector.Filter(prefer, nodes), name)
+			}
+			if n == nil {
+				n = sh.GetServiceNode(nodes, name)
+			}
+			if n != nil {
+				n.Acquire()
+			}
+			return n, nil
+		}
+	}
+	return nil, ErrNoService
+}
+
+// Context 后台协程使用的ctx，Close时被取消
+func (d *Discovery) Context() context.Context {
+	return d.ctx
+}
+
+// Go 启动受管理的后台协程，fn需在ctx结束后尽快返回；已关闭时返回ErrClosed
+func (d *Discovery) Go(fn func(ctx context.Context)) error {
+	return d.GoContext(d.ctx, fn)
+}
+
+// GoContext 同Go，ctx需派生自Context()，用于单独停止某个协程
+func (d *Discovery) GoContext(ctx context.Context, fn func(ctx context.Context)) error {
+	d.lifeMutex.Lock()
+	defer d.lifeMutex.Unlock()
+	if d.ctx.Err() != nil {
+		return ErrClosed
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		fn(ctx)
+	}()
+	return nil
+}
+
+// Closed 是否已调用Stop
+func (d *Discovery) Closed() bool {
+	return d.ctx.Err() != nil
+}
+
+// Stop 通知所有后台协程退出
+func (d *Discovery) Stop() {
+	d.lifeMutex.Lock()
+	defer d.lifeMutex.Unlock()
+	d.cancel()
+}
+
+// Wait 等待后台协程全部退出后关闭已发现节点的连接，ctx结束时不再等待
+func (d *Discovery) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	d.rwmutex.Lock()
+	defer d.rwmutex.Unlock()
+	for name, nodes := range d.discovered {
+		for _, n := range nodes {
+			n.Close()
+		}
+		delete(d.discovered, name)
+	}
+	return nil
+}
+
+// SetMaintenance 设置或取消节点的维护状态，原因记录在元数据中；取消时只清除维护状态，不影响摘流等其他状态
+func SetMaintenance(s *config.RegisterNode, enable bool, reason string) {
+	md := make(map[string]string, len(s.Metadata)+1)
+	for k, v := range s.Metadata {
+		md[k] = v
+	}
+	if enable {
+		s.Status = config.StatusMaintenance
+		md[config.MetaMaintenanceReason] = reason
+	} else {
+		if s.Status == config.StatusMaintenance {
+			s.Status = ""
+		}
+		delete(md, config.MetaMaintenanceReason)
+	}
+	s.Metadata = md
+}
+
+// SetUnhealthy 设置或取消节点的不健康状态，原因记录在元数据中；只在没有其他状态时设置，取消时只清除不健康状态
+func SetUnhealthy(s *config.RegisterNode, unhealthy bool, reason string) {
+	md := make(map[string]string, len(s.Metadata)+1)
+	for k, v := range s.Metadata {
+		md[k] = v
+	}
+	if unhealthy {
+		if s.Status == "" {
+			s.Status = config.StatusUnhealthy
+			md[config.MetaUnhealthyReason] = reason
+		}
+	} else if s.Status == config.StatusUnhealthy {
+		s.Status = ""
+		delete(md, config.MetaUnhealthyReason)
+	}
+	s.Metadata = md
+}
+
+// SortNodes 按id排序
+func SortNodes(nodes []*config.RegisterNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Id < nodes[j].Id
+	})
+}
+
+// Sleep 等待d，期间ctx结束返回false
+func Sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -6,12 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"reflect"
 	"sync"
 	"time"
 
 	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/driver"
 	"github.com/baowk/dilu-rd/models"
-	"github.com/baowk/dilu-rd/scheduling"
 
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
@@ -19,11 +20,18 @@ import (
 )
 
 type EtcdClient struct {
-	client             *clientv3.Client
-	rwmutex            sync.RWMutex
-	registered         map[string]*config.RegisterNode
-	discovered         map[string][]*models.ServiceNode //已发现的服务
-	schedulingHandlers map[string]scheduling.SchedulingHandler
+	*driver.Discovery
+	client     *clientv3.Client
+	rwmutex    sync.RWMutex
+	registered map[string]*registration //已注册的服务，key为服务id
+}
+
+// registration 一个注册节点及其租约
+type registration struct {
+	node   *config.RegisterNode
+	key    string
+	lease  clientv3.LeaseID
+	cancel context.CancelFunc //停止续约
 }
 
 func NewClient(cfg *clientv3.Config) (*EtcdClient, error) {
@@ -32,160 +40,385 @@ func NewClient(cfg *clientv3.Config) (*EtcdClient, error) {
 		return nil, err
 	}
 	return &EtcdClient{
-		client:             client,
-		rwmutex:            sync.RWMutex{},
-		discovered:         make(map[string][]*models.ServiceNode),
-		registered:         make(map[string]*config.RegisterNode),
-		schedulingHandlers: make(map[string]scheduling.SchedulingHandler),
+		Discovery:  driver.NewDiscovery(),
+		client:     client,
+		rwmutex:    sync.RWMutex{},
+		registered: make(map[string]*registration),
 	}, nil
 }
 
 func (c *EtcdClient) Register(s *config.RegisterNode) error {
-	var err error
-	go func() {
-		kv := clientv3.NewKV(c.client)
-		lease := clientv3.NewLease(c.client)
-		var curLeaseId clientv3.LeaseID = 0
-		for {
-			if curLeaseId == 0 {
-				leaseResp, err := lease.Grant(context.TODO(), int64(s.Timeout.Seconds()))
-				if err != nil {
-					slog.Error("Grant err", err)
-					return
-				}
-				key := fmt.Sprintf("%s%d", s.Name, leaseResp.ID)
-				b, err := json.Marshal(s)
-				if err != nil {
-					slog.Error("json marshal err", err)
-				}
-				if _, err := kv.Put(context.TODO(), key, string(b), clientv3.WithLease(clientv3.LeaseID(leaseResp.ID))); err != nil {
-					slog.Error("put err", err)
-					return
-				}
-				c.registered[key] = s
-				curLeaseId = clientv3.LeaseID(leaseResp.ID)
-			} else {
-				slog.Debug("key:", curLeaseId)
-				// 续约租约，如果租约已经过期将curLeaseId复位到0重新走创建租约的逻辑
-				if _, err := lease.KeepAliveOnce(context.TODO(), curLeaseId); err == rpctypes.ErrLeaseNotFound {
-					slog.Error("keepalive err", err)
-					curLeaseId = 0
-					continue
-				}
+	return c.RegisterContext(context.Background(), s)
+}
+
+// RegisterContext 写入注册信息后在后台定时续约，ctx只作用于首次写入，续约在Deregister或Close时停止。
+// 同一id重复注册时，服务名和超时时间不变则原地更新，否则先注销原来的注册再重新注册
+func (c *EtcdClient) RegisterContext(ctx context.Context, s *config.RegisterNode) error {
+	if c.Closed() {
+		return driver.ErrClosed
+	}
+	c.rwmutex.Lock()
+	old := c.registered[s.Id]
+	if old != nil && (old.node.Name != s.Name || old.node.Timeout != s.Timeout) {
+		//租约时长和key都需要变化，先删除原来的key，避免监听方收到删除事件时把新的注册一起移除
+		delete(c.registered, s.Id)
+		c.rwmutex.Unlock()
+		if err := c.remove(ctx, old); err != nil {
+			slog.Error("register remove old", "id", s.Id, "err", err)
+		}
+		old = nil
+	} else {
+		c.rwmutex.Unlock()
+	}
+	if old != nil {
+		return c.update(ctx, old, s)
+	}
+	r := &registration{node: s}
+	if err := c.put(ctx, r); err != nil {
+		slog.Error("register", "err", err)
+		return err
+	}
+	kctx, cancel := context.WithCancel(c.Context())
+	r.cancel = cancel
+	c.rwmutex.Lock()
+	c.registered[s.Id] = r
+	c.rwmutex.Unlock()
+	return c.GoContext(kctx, func(ctx context.Context) {
+		c.keepAlive(ctx, r)
+	})
+}
+
+func (c *EtcdClient) Update(s *config.RegisterNode) error {
+	return c.UpdateContext(context.Background(), s)
+}
+
+// UpdateContext 更新已注册节点的标签、权重、元数据等，沿用原来的key和租约写入，监听方收到的是更新事件。
+// 服务名不能修改；超时时间变化时需要新的租约，会重新注册
+func (c *EtcdClient) UpdateContext(ctx context.Context, s *config.RegisterNode) error {
+	c.rwmutex.RLock()
+	r, ok := c.registered[s.Id]
+	var name string
+	if ok {
+		name = r.node.Name
+	}
+	c.rwmutex.RUnlock()
+	if !ok {
+		return driver.ErrNotRegistered
+	}
+	if name != s.Name {
+		return fmt.Errorf("update %s: name cannot be changed from %s to %s", s.Id, name, s.Name)
+	}
+	return c.RegisterContext(ctx, s)
+}
+
+// update 用原来的key和租约写入新的注册信息，租约已过期时由keepAlive重新申请
+func (c *EtcdClient) update(ctx context.Context, r *registration, s *config.RegisterNode) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	c.rwmutex.Lock()
+	r.node = s
+	key, lease := r.key, r.lease
+	c.rwmutex.Unlock()
+	if lease == 0 {
+		return nil
+	}
+	if _, err := c.client.Put(ctx, key, string(b), clientv3.WithLease(lease)); err != nil {
+		slog.Error("update", "id", s.Id, "err", err)
+		return err
+	}
+	return nil
+}
+
+// put 申请租约并写入注册信息
+func (c *EtcdClient) put(ctx context.Context, r *registration) error {
+	c.rwmutex.RLock()
+	node := r.node
+	c.rwmutex.RUnlock()
+	leaseResp, err := c.client.Grant(ctx, int64(node.Timeout.Seconds()))
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%d", node.Name, leaseResp.ID)
+	b, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	if _, err := c.client.Put(ctx, key, string(b), clientv3.WithLease(leaseResp.ID)); err != nil {
+		return err
+	}
+	c.rwmutex.Lock()
+	r.key = key
+	r.lease = leaseResp.ID
+	c.rwmutex.Unlock()
+	return nil
+}
+
+// interval 续约间隔，节点可能被更新，需加锁读取
+func (c *EtcdClient) interval(r *registration) time.Duration {
+	c.rwmutex.RLock()
+	defer c.rwmutex.RUnlock()
+	return r.node.Interval
+}
+
+func (c *EtcdClient) keepAlive(ctx context.Context, r *registration) {
+	for driver.Sleep(ctx, c.interval(r)) {
+		c.rwmutex.RLock()
+		lease := r.lease
+		c.rwmutex.RUnlock()
+		if lease == 0 {
+			if err := c.put(ctx, r); err != nil && ctx.Err() == nil {
+				slog.Error("put", "err", err)
 			}
-			time.Sleep(s.Interval)
+			continue
+		}
+		slog.Debug("keepalive", "lease", lease)
+		// 续约租约，如果租约已经过期将lease复位到0重新走创建租约的逻辑
+		if _, err := c.client.KeepAliveOnce(ctx, lease); err == rpctypes.ErrLeaseNotFound {
+			slog.Error("keepalive", "err", err)
+			c.rwmutex.Lock()
+			r.lease = 0
+			c.rwmutex.Unlock()
 		}
-	}()
-	return err
+	}
 }
 
 func (c *EtcdClient) Deregister() {
-	kv := clientv3.NewKV(c.client)
-	for k, _ := range c.registered {
-		kv.Delete(context.TODO(), k)
+	c.DeregisterContext(context.Background())
+}
+
+// DeregisterContext 停止续约，删除注册信息并释放租约
+func (c *EtcdClient) DeregisterContext(ctx context.Context) error {
+	c.rwmutex.Lock()
+	registered := c.registered
+	c.registered = make(map[string]*registration)
+	c.rwmutex.Unlock()
+	var errs []error
+	for _, r := range registered {
+		if err := c.remove(ctx, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RegisteredNode 返回已注册节点的副本
+func (c *EtcdClient) RegisteredNode(id string) (*config.RegisterNode, bool) {
+	c.rwmutex.RLock()
+	defer c.rwmutex.RUnlock()
+	r, ok := c.registered[id]
+	if !ok {
+		return nil, false
 	}
+	n := *r.node
+	return &n, true
+}
+
+// RegisteredNodes 返回所有已注册节点的副本，按id排序
+func (c *EtcdClient) RegisteredNodes() []*config.RegisterNode {
+	c.rwmutex.RLock()
+	nodes := make([]*config.RegisterNode, 0, len(c.registered))
+	for _, r := range c.registered {
+		n := *r.node
+		nodes = append(nodes, &n)
+	}
+	c.rwmutex.RUnlock()
+	driver.SortNodes(nodes)
+	return nodes
+}
+
+func (c *EtcdClient) SetMaintenance(id string, enable bool, reason string) error {
+	return c.SetMaintenanceContext(context.Background(), id, enable, reason)
+}
+
+// SetMaintenanceContext 通过注册信息中的状态字段设置或取消维护，消费方不再调度维护中的节点
+func (c *EtcdClient) SetMaintenanceContext(ctx context.Context, id string, enable bool, reason string) error {
+	node, ok := c.RegisteredNode(id)
+	if !ok {
+		return driver.ErrNotRegistered
+	}
+	driver.SetMaintenance(node, enable, reason)
+	return c.UpdateContext(ctx, node)
+}
+
+func (c *EtcdClient) DeregisterNode(id string) error {
+	return c.DeregisterNodeContext(context.Background(), id)
+}
+
+// DeregisterNodeContext 只注销指定id的节点，其他节点不受影响
+func (c *EtcdClient) DeregisterNodeContext(ctx context.Context, id string) error {
+	c.rwmutex.Lock()
+	r, ok := c.registered[id]
+	delete(c.registered, id)
+	c.rwmutex.Unlock()
+	if !ok {
+		return driver.ErrNotRegistered
+	}
+	return c.remove(ctx, r)
+}
+
+// remove 停止续约，删除注册信息并释放租约
+func (c *EtcdClient) remove(ctx context.Context, r *registration) error {
+	r.cancel()
+	c.rwmutex.RLock()
+	key, lease := r.key, r.lease
+	c.rwmutex.RUnlock()
+	if _, err := c.client.Delete(ctx, key); err != nil {
+		return err
+	}
+	if _, err := c.client.Revoke(ctx, lease); err != nil && err != rpctypes.ErrLeaseNotFound {
+		return err
+	}
+	return nil
 }
 
 func (c *EtcdClient) Watch(s *config.DiscoveryNode) error {
-	go func() {
-		func() {
-			kv := clientv3.NewKV(c.client)
-			rangeResp, err := kv.Get(context.TODO(), s.Name, clientv3.WithPrefix())
-			if err != nil {
-				slog.Error("GetKey err:", err)
+	return c.WatchContext(context.Background(), s)
+}
+
+// WatchContext 同步加载一次服务节点后在后台监听变化，ctx只作用于首次加载，监听在Close时停止
+func (c *EtcdClient) WatchContext(ctx context.Context, s *config.DiscoveryNode) error {
+	if c.Closed() {
+		return driver.ErrClosed
+	}
+	wctx, err := c.AddService(s)
+	if err != nil {
+		return err
+	}
+	rev := c.load(ctx, s)
+	return c.GoContext(wctx, func(ctx context.Context) {
+		for {
+			c.watch(ctx, s, rev)
+			// 监听中断(如历史版本被压缩、连接断开)时稍后重新加载全量节点
+			if !driver.Sleep(ctx, time.Second) {
+				return
 			}
-			for _, kv := range rangeResp.Kvs {
-				c.putServiceNode(kv.Value, s)
+			rev = c.load(ctx, s)
+		}
+	})
+}
+
+// load 加载服务的全部节点，返回读取时的版本号，出错时返回0
+func (c *EtcdClient) load(ctx context.Context, s *config.DiscoveryNode) int64 {
+	rangeResp, err := c.client.Get(ctx, s.Name, clientv3.WithPrefix())
+	if err != nil {
+		slog.Error("get", "err", err)
+		return 0
+	}
+	ids := make(map[string]bool, len(rangeResp.Kvs))
+	for _, kv := range rangeResp.Kvs {
+		if rs := c.putServiceNode(kv.Value, s); rs != nil {
+			ids[rs.Id] = true
+		}
+	}
+	c.UpdateNodes(s.Name, func(vs []*models.ServiceNode) []*models.ServiceNode {
+		nodes := make([]*models.ServiceNode, 0, len(vs))
+		for _, v := range vs {
+			if ids[v.Id] {
+				nodes = append(nodes, v)
+			} else {
+				v.Close()
 			}
-		}()
-
-		watcher := clientv3.NewWatcher(c.client)
-		// Watch 服务目录下的更新
-		watchChan := watcher.Watch(context.TODO(), s.Name, clientv3.WithPrefix())
-		for watchResp := range watchChan {
-			for _, event := range watchResp.Events {
-				slog.Info("Events ", s.Name, string(event.Kv.Value))
-				switch event.Type {
-				case mvccpb.PUT: //PUT事件，目录下有了新key
-					c.putServiceNode(event.Kv.Value, s)
-				case mvccpb.DELETE: //DELETE事件，目录中有key被删掉(Lease过期，key 也会被删掉)
-					c.delServiceNode(string(event.Kv.Key), s)
+		}
+		return nodes
+	})
+	return rangeResp.Header.Revision
+}
+
+// watch 从rev之后开始监听服务目录下的更新，直到ctx结束或监听中断
+func (c *EtcdClient) watch(ctx context.Context, s *config.DiscoveryNode, rev int64) {
+	opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithPrevKV()}
+	if rev > 0 {
+		opts = append(opts, clientv3.WithRev(rev+1))
+	}
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	for watchResp := range c.client.Watch(wctx, s.Name, opts...) {
+		if err := watchResp.Err(); err != nil {
+			slog.Error("watch", "name", s.Name, "err", err)
+			return
+		}
+		for _, event := range watchResp.Events {
+			slog.Debug("watch", "name", s.Name, "type", event.Type, "key", string(event.Kv.Key))
+			switch event.Type {
+			case mvccpb.PUT: //PUT事件，目录下有了新key
+				c.putServiceNode(event.Kv.Value, s)
+			case mvccpb.DELETE: //DELETE事件，目录中有key被删掉(Lease过期，key 也会被删掉)
+				if event.PrevKv == nil {
+					continue
+				}
+				var rs models.ServiceNode
+				if err := json.Unmarshal(event.PrevKv.Value, &rs); err != nil {
+					slog.Error("unmarshal", "err", err)
+					continue
 				}
+				c.delServiceNode(rs.Id, s)
 			}
 		}
-	}()
-	return nil
+	}
 }
 
-func (c *EtcdClient) putServiceNode(data []byte, s *config.DiscoveryNode) {
-	c.rwmutex.Lock()
-	defer c.rwmutex.Unlock()
-	slog.Debug("-----put ", s.Name, string(data))
+// putServiceNode 新增或更新节点，返回解析出的节点，元数据不匹配或解析失败时返回nil
+func (c *EtcdClient) putServiceNode(data []byte, s *config.DiscoveryNode) *models.ServiceNode {
 	var rs models.ServiceNode
 	err := json.Unmarshal(data, &rs)
 	if err != nil {
-		slog.Error("unmarshal err", err)
+		slog.Error("unmarshal", "err", err)
+		return nil
 	}
-	if vs, ok := c.discovered[s.Name]; ok {
-		found := false
-		for _, v := range vs {
-			if v.Id == rs.Id {
-				slog.Debug("-----update ", s.Name, rs)
-				v.Addr = rs.Addr
-				v.Port = rs.Port
-				v.Tags = rs.Tags
-				v.Weight = rs.Weight
-				v.Namespace = rs.Namespace
-				v.Protocol = rs.Protocol
-				v.SetEnable(true)
-				v.ClearFailCnt()
-				found = true
-				break
+	rs.Weight = rs.RegisterNode.Weight
+	if rs.Weight == 0 { //未填充默认值的客户端注册的节点，与consul agent的默认权重一致
+		rs.Weight = config.DefaultWeight
+	}
+	if !rs.MatchMetadata(s.Metadata) {
+		c.delServiceNode(rs.Id, s)
+		return nil
+	}
+	c.UpdateNodes(s.Name, func(vs []*models.ServiceNode) []*models.ServiceNode {
+		for i, v := range vs {
+			if v.Id == rs.Id && (v.Addr != rs.Addr || v.Port != rs.Port) { //地址变化时重建，旧的grpc连接随之关闭
+				slog.Debug("rebuild", "name", s.Name, "id", rs.Id)
+				v.Close()
+				rs.SetEnable(true)
+				vs[i] = &rs
+				return vs
+			}
+			if v.Id == rs.Id { //替换为沿用运行状态的新节点，失败计数和摘除状态保留，被摘除的节点仍由恢复协程探测后启用
+				if v.Weight == rs.Weight && reflect.DeepEqual(v.RegisterNode, rs.RegisterNode) {
+					return vs
+				}
+				slog.Debug("update", "name", s.Name, "id", rs.Id)
+				vs[i] = v.Renew(rs.RegisterNode, rs.Weight)
+				return vs
 			}
 		}
-		if !found {
-			slog.Debug("-----add other ", s.Name, rs)
-			rs.SetEnable(true)
-			rs.ClearFailCnt()
-			vs = append(vs, &rs)
-			c.discovered[s.Name] = vs
-		}
-	} else {
-		slog.Debug("-----add first", s.Name, rs)
+		slog.Debug("add", "name", s.Name, "id", rs.Id)
 		rs.SetEnable(true)
-		rs.ClearFailCnt()
-		c.discovered[s.Name] = []*models.ServiceNode{&rs}
-	}
-	c.schedulingHandlers[s.Name] = scheduling.GetHandler(s.SchedulingAlgorithm)
+		return append(vs, &rs)
+	})
+	return &rs
 }
 
 func (c *EtcdClient) delServiceNode(curId string, s *config.DiscoveryNode) {
-	c.rwmutex.RLock()
-	defer c.rwmutex.RUnlock()
-	slog.Debug("-----del ", s.Name, curId)
-	if vs, ok := c.discovered[s.Name]; ok {
+	slog.Debug("del", "name", s.Name, "id", curId)
+	c.UpdateNodes(s.Name, func(vs []*models.ServiceNode) []*models.ServiceNode {
 		for i, v := range vs {
 			if v.Id == curId {
-				slog.Debug("-----del ", s.Name, curId)
 				v.Close()
-				vs = append(vs[:i], vs[i+1:]...)
-				break
+				return append(vs[:i], vs[i+1:]...)
 			}
 		}
-		c.discovered[s.Name] = vs
-	} else {
-		slog.Info("not found")
-	}
+		return vs
+	})
 }
 
-func (c *EtcdClient) GetService(name string, clientIp string) (*models.ServiceNode, error) {
-	c.rwmutex.RLock()
-	defer c.rwmutex.RUnlock()
-	slog.Debug("-----get ", name)
-	if rs, ok := c.discovered[name]; ok && len(rs) > 0 {
-		if sh, ok := c.schedulingHandlers[name]; ok {
-			return sh.GetServiceNode(rs, name), nil
-		}
+// Close 停止所有后台协程，注销已注册的服务，关闭已发现节点的连接和etcd客户端
+func (c *EtcdClient) Close(ctx context.Context) error {
+	c.Stop()
+	err := c.DeregisterContext(ctx)
+	if werr := c.Wait(ctx); werr != nil {
+		err = errors.Join(err, werr)
 	}
-	return nil, errors.New("no service")
+	return errors.Join(err, c.client.Close())
 }
@@ -0,0 +1,117 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/driver"
+	"github.com/baowk/dilu-rd/models"
+)
+
+func node(t *testing.T, c *EtcdClient, name, id string) *models.ServiceNode {
+	t.Helper()
+	var rs *models.ServiceNode
+	c.UpdateNodes(name, func(vs []*models.ServiceNode) []*models.ServiceNode {
+		for _, v := range vs {
+			if v.Id == id {
+				rs = v
+			}
+		}
+		return vs
+	})
+	if rs == nil {
+		t.Fatalf("node %s not discovered", id)
+	}
+	return rs
+}
+
+func TestPutKeepsEjection(t *testing.T) {
+	c := &EtcdClient{Discovery: driver.NewDiscovery(), registered: make(map[string]*registration)}
+	defer func() { //没有etcd连接，只停止后台协程
+		c.Stop()
+		c.Wait(context.Background())
+	}()
+	s := &config.DiscoveryNode{Enable: true, Name: "svc"}
+	if _, err := c.AddService(s); err != nil {
+		t.Fatal(err)
+	}
+	put := func(rs config.RegisterNode) {
+		data, _ := json.Marshal(rs)
+		c.putServiceNode(data, s)
+	}
+	rs := config.RegisterNode{Id: "a", Name: "svc", Addr: "10.0.0.1", Port: 80, Protocol: "grpc", FailLimit: 1}
+	put(rs)
+	a := node(t, c, "svc", "a")
+	a.IncrFailCnt()
+	a.IncrFailCnt()
+	if _, _, ok := a.Ejected(); !ok || a.Available() {
+		t.Fatal("node should be ejected after exceeding FailLimit")
+	}
+
+	rs.Status = config.StatusUnhealthy //状态、元数据等更新
+	put(rs)
+	u := node(t, c, "svc", "a")
+	if u == a || u.Status != config.StatusUnhealthy || a.Status != "" {
+		t.Fatalf("update should swap in a new node and leave the returned one untouched: same=%v status=%q old=%q", u == a, u.Status, a.Status)
+	}
+	if _, ejections, ok := u.Ejected(); !ok || ejections != 1 || u.Enable() {
+		t.Errorf("update re-enabled the ejected node: ejected=%v ejections=%d enable=%v", ok, ejections, u.Enable())
+	}
+	put(rs)
+	if node(t, c, "svc", "a") != u {
+		t.Error("unchanged put should keep the node")
+	}
+
+	rs.Status = ""
+	rs.Addr = "10.0.0.2"
+	put(rs)
+	b := node(t, c, "svc", "a")
+	if b == u || !b.Available() {
+		t.Errorf("address change should rebuild the node: same=%v available=%v", b == u, b.Available())
+	}
+	if _, err := u.GetGrpcConn(); err == nil {
+		t.Error("replaced node should be closed")
+	}
+}
+
+// 调用方读取已返回的节点时，监听协程的更新不应修改该节点，用-race检查
+func TestPutDoesNotModifyReturnedNode(t *testing.T) {
+	c := &EtcdClient{Discovery: driver.NewDiscovery(), registered: make(map[string]*registration)}
+	defer func() {
+		c.Stop()
+		c.Wait(context.Background())
+	}()
+	s := &config.DiscoveryNode{Enable: true, Name: "svc"}
+	if _, err := c.AddService(s); err != nil {
+		t.Fatal(err)
+	}
+	rs := config.RegisterNode{Id: "a", Name: "svc", Addr: "10.0.0.1", Port: 80, Protocol: "grpc", Metadata: map[string]string{"version": "0"}}
+	data, _ := json.Marshal(rs)
+	c.putServiceNode(data, s)
+	a := node(t, c, "svc", "a")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			rs.Metadata = map[string]string{"version": fmt.Sprint(i)}
+			rs.Status = []string{"", config.StatusDraining}[i%2]
+			data, _ := json.Marshal(rs)
+			c.putServiceNode(data, s)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_ = a.GetMetadata("version") + a.GetUrl()
+		a.Available()
+	}
+	<-done
+	if a.GetMetadata("version") != "0" {
+		t.Errorf("returned node was modified: version=%s", a.GetMetadata("version"))
+	}
+	if got := node(t, c, "svc", "a"); got.GetMetadata("version") != "99" {
+		t.Errorf("discovered version = %s, want 99", got.GetMetadata("version"))
+	}
+}
@@ -0,0 +1,148 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/grpc/pb/health"
+	"github.com/baowk/dilu-rd/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// healthCheckDefaults 填充主动健康检查的默认值
+func healthCheckDefaults(hc *config.ActiveHealthCheck) config.ActiveHealthCheck {
+	c := *hc
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = probeTimeout
+	}
+	if c.Jitter <= 0 {
+		c.Jitter = 0.2
+	}
+	if c.UnhealthyThreshold <= 0 {
+		c.UnhealthyThreshold = 2
+	}
+	if c.HealthyThreshold <= 0 {
+		c.HealthyThreshold = 1
+	}
+	return c
+}
+
+// activeCheck 每个检查间隔探测一遍服务的所有节点，各节点的探测时间在间隔的Jitter比例内随机分散，
+// 避免多个消费方同时探测同一节点
+func (d *Discovery) activeCheck(ctx context.Context, s *config.DiscoveryNode) {
+	hc := healthCheckDefaults(s.HealthCheck)
+	spread := time.Duration(float64(hc.Interval) * hc.Jitter)
+	for Sleep(ctx, hc.Interval-spread+jitter(spread)) {
+		nodes, targets := d.snapshot(s.Name)
+		var wg sync.WaitGroup
+		for i, n := range nodes {
+			wg.Add(1)
+			go func(n *models.ServiceNode, target *config.RegisterNode) {
+				defer wg.Done()
+				if !Sleep(ctx, jitter(spread)) {
+					return
+				}
+				pctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+				err := Probe(pctx, target)
+				cancel()
+				if ctx.Err() != nil {
+					return
+				}
+				if n.ReportProbe(err == nil, hc.HealthyThreshold, hc.UnhealthyThreshold) {
+					if err != nil {
+						slog.Warn("health check failed", "name", s.Name, "id", n.Id, "err", err)
+					} else {
+						slog.Info("health check recovered", "name", s.Name, "id", n.Id)
+					}
+				}
+			}(n, &targets[i])
+		}
+		wg.Wait()
+	}
+}
+
+// snapshot 服务当前的节点及其注册信息的副本，探测期间不持有锁
+func (d *Discovery) snapshot(name string) ([]*models.ServiceNode, []config.RegisterNode) {
+	d.rwmutex.RLock()
+	defer d.rwmutex.RUnlock()
+	nodes := append([]*models.ServiceNode(nil), d.discovered[name]...)
+	targets := make([]config.RegisterNode, len(nodes))
+	for i, n := range nodes {
+		targets[i] = n.RegisterNode
+	}
+	return nodes, targets
+}
+
+// jitter 返回[0, d)内的随机时长
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// Probe 探测节点是否健康：HealthCheck为http地址时发送GET请求，要求返回2xx或3xx；
+// grpc节点调用grpc健康检查，HealthCheck格式同consul，为 地址:端口/服务名；否则与节点建立TCP连接
+func Probe(ctx context.Context, n *config.RegisterNode) error {
+	if strings.HasPrefix(n.HealthCheck, "http://") || strings.HasPrefix(n.HealthCheck, "https://") {
+		return probeHttp(ctx, n.HealthCheck)
+	}
+	addr := net.JoinHostPort(n.Addr, fmt.Sprint(n.Port))
+	if n.Protocol == "grpc" {
+		service := ""
+		if n.HealthCheck != "" {
+			addr, service, _ = strings.Cut(n.HealthCheck, "/")
+		}
+		return probeGrpc(ctx, addr, service)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func probeHttp(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("health check status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func probeGrpc(ctx context.Context, addr, service string) error {
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := health.NewHealthClient(conn).Check(ctx, &health.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	if resp.Status != health.HealthCheckResponse_SERVING {
+		return fmt.Errorf("health check status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package driver
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/models"
+)
+
+// outlierDefaults 填充异常检测的默认值
+func outlierDefaults(od *config.OutlierDetection) config.OutlierDetection {
+	c := *od
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.BaseEjectionTime <= 0 {
+		c.BaseEjectionTime = 30 * time.Second
+	}
+	if c.MaxEjectionPercent <= 0 {
+		c.MaxEjectionPercent = 10
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 5
+	}
+	if c.MinHosts <= 0 {
+		c.MinHosts = 3
+	}
+	if c.SuccessRateStdevFactor <= 0 {
+		c.SuccessRateStdevFactor = 1.9
+	}
+	return c
+}
+
+// detectOutliers 每个检测间隔比较服务中各节点的成功率和耗时，摘除明显差于其他节点的异常节点
+func (d *Discovery) detectOutliers(ctx context.Context, s *config.DiscoveryNode) {
+	od := outlierDefaults(s.OutlierDetection)
+	for Sleep(ctx, od.Interval) {
+		d.rwmutex.RLock() //检测期间读取节点状态，持有读锁避免与发现协程更新节点冲突
+		ejected := detect(d.discovered[s.Name], &od)
+		d.rwmutex.RUnlock()
+		for _, n := range ejected {
+			slog.Warn("outlier", "name", s.Name, "id", n.Id)
+		}
+	}
+}
+
+type nodeStats struct {
+	node    *models.ServiceNode
+	rate    float64       //成功率
+	latency time.Duration //平均耗时，没有上报耗时为0
+}
+
+// detect 统计并摘除异常节点，返回本次被摘除的节点。
+// 被摘除的节点数不超过节点总数的MaxEjectionPercent，但至少允许摘除1个，且摘除后至少保留1个可用节点；
+// 异常程度高的节点优先摘除
+func detect(nodes []*models.ServiceNode, od *config.OutlierDetection) []*models.ServiceNode {
+	ejected, available := 0, 0
+	var candidates []nodeStats
+	for _, n := range nodes {
+		if n.Available() {
+			available++
+		}
+		st := n.TakeStats()
+		if n.OutlierEjected() {
+			ejected++
+			continue
+		}
+		total := st.Success + st.Failure
+		if total < od.MinRequests {
+			continue
+		}
+		ns := nodeStats{node: n, rate: float64(st.Success) / float64(total)}
+		if st.Timed > 0 {
+			ns.latency = st.Latency / time.Duration(st.Timed)
+		}
+		candidates = append(candidates, ns)
+	}
+	if len(candidates) < od.MinHosts {
+		return nil
+	}
+
+	score := make(map[*models.ServiceNode]float64) //超出阈值的程度
+	mean, stdev := rateStats(candidates)
+	threshold := mean - od.SuccessRateStdevFactor*stdev
+	for _, c := range candidates {
+		if c.rate < threshold {
+			score[c.node] += (threshold - c.rate) / math.Max(threshold, 0.01)
+		}
+	}
+	if od.LatencyFactor > 0 {
+		if median := medianLatency(candidates); median > 0 {
+			limit := float64(median) * od.LatencyFactor
+			for _, c := range candidates {
+				if float64(c.latency) > limit {
+					score[c.node] += (float64(c.latency) - limit) / limit
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return score[candidates[i].node] > score[candidates[j].node]
+	})
+	limit := max(1, len(nodes)*od.MaxEjectionPercent/100)
+	var rs []*models.ServiceNode
+	for _, c := range candidates {
+		if score[c.node] == 0 {
+			c.node.DecayOutlier()
+			continue
+		}
+		ready := c.node.Available()
+		if ejected >= limit || (ready && available <= 1) {
+			continue
+		}
+		c.node.EjectOutlier(od.BaseEjectionTime)
+		ejected++
+		if ready {
+			available--
+		}
+		rs = append(rs, c.node)
+	}
+	return rs
+}
+
+func rateStats(candidates []nodeStats) (mean, stdev float64) {
+	for _, c := range candidates {
+		mean += c.rate
+	}
+	mean /= float64(len(candidates))
+	for _, c := range candidates {
+		stdev += (c.rate - mean) * (c.rate - mean)
+	}
+	return mean, math.Sqrt(stdev / float64(len(candidates)))
+}
+
+// medianLatency 上报了耗时的节点的平均耗时中位数
+func medianLatency(candidates []nodeStats) time.Duration {
+	var ls []time.Duration
+	for _, c := range candidates {
+		if c.latency > 0 {
+			ls = append(ls, c.latency)
+		}
+	}
+	if len(ls) == 0 {
+		return 0
+	}
+	sort.Slice(ls, func(i, j int) bool { return ls[i] < ls[j] })
+	return ls[len(ls)/2]
+}
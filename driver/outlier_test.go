@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/models"
+)
+
+// pool 创建n个节点，每个节点20次请求，bad中的节点一半失败
+func pool(n int, bad int) []*models.ServiceNode {
+	var nodes []*models.ServiceNode
+	for i := 0; i < n; i++ {
+		node := &models.ServiceNode{}
+		node.Id = fmt.Sprint(i)
+		node.FailLimit = 100
+		node.SetEnable(true)
+		for j := 0; j < 20; j++ {
+			var err error
+			if i == bad && j%2 == 0 {
+				err = errors.New("fail")
+			}
+			node.Report(err, time.Millisecond)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func TestDetectSmallPool(t *testing.T) {
+	od := outlierDefaults(&config.OutlierDetection{})
+	nodes := pool(5, 0)
+	rs := detect(nodes, &od)
+	if len(rs) != 1 || rs[0] != nodes[0] {
+		t.Fatalf("ejected %d nodes, want node 0 ejected in a 5 node pool with the default 10%%", len(rs))
+	}
+	if nodes[0].Available() {
+		t.Error("ejected node should not be available")
+	}
+}
+
+func TestDetectKeepsOneAvailable(t *testing.T) {
+	od := outlierDefaults(&config.OutlierDetection{MaxEjectionPercent: 100})
+	nodes := pool(5, 0)
+	for _, n := range nodes[1:] {
+		n.SetEnable(false)
+	}
+	if rs := detect(nodes, &od); len(rs) != 0 {
+		t.Errorf("ejected the last available node")
+	}
+}
@@ -0,0 +1,57 @@
+package driver
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+)
+
+const (
+	defaultRetryTime = 30 * time.Second //被摘除节点的默认重试间隔
+	maxRetryShift    = 5                //连续摘除时重试间隔最多翻倍到32倍
+	probeTimeout     = 3 * time.Second
+)
+
+// retryDelay 第ejections次被摘除后等待多久再探测
+func retryDelay(base time.Duration, ejections int) time.Duration {
+	shift := ejections - 1
+	if shift < 0 {
+		shift = 0
+	} else if shift > maxRetryShift {
+		shift = maxRetryShift
+	}
+	return base << shift
+}
+
+// recovery 定期检查服务中被摘除的节点，到达重试时间后先探测，探测成功才重新启用，失败则加倍等待时间
+func (d *Discovery) recovery(ctx context.Context, s *config.DiscoveryNode) {
+	base := time.Duration(s.RetryTime) * time.Second
+	if base <= 0 {
+		base = defaultRetryTime
+	}
+	for Sleep(ctx, time.Second) {
+		nodes, targets := d.snapshot(s.Name)
+		now := time.Now()
+		for i, n := range nodes {
+			at, ejections, ok := n.Ejected()
+			if !ok || now.Sub(at) < retryDelay(base, ejections) {
+				continue
+			}
+			pctx, cancel := context.WithTimeout(ctx, probeTimeout)
+			err := Probe(pctx, &targets[i])
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				slog.Warn("recovery probe", "name", s.Name, "id", n.Id, "ejections", ejections, "err", err)
+				n.Reject()
+				continue
+			}
+			slog.Info("recovery", "name", s.Name, "id", n.Id)
+			n.Readmit(retryDelay(base, ejections)) //正常运行超过本次等待时间后不再累加退避
+		}
+	}
+}
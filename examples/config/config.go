@@ -42,14 +42,13 @@ func GetConfig() *config.Config {
 		Registers: []*config.RegisterNode{
 			&config.RegisterNode{
 				//Namespace: "dilu",
-				Name:        ServiceName,
-				Addr:        serverAddr,
-				Port:        serverPort,
-				Protocol:    "http",
-				HealthCheck: fmt.Sprintf("http://%s:%d/api/health", serverAddr, serverPort),
-				Tags:        []string{"dev"},
-				Weight:      100,
-				Id:          fmt.Sprintf("%s:%d", serverAddr, serverPort),
+				Name:     ServiceName,
+				Addr:     serverAddr,
+				Port:     serverPort,
+				Protocol: "http", //未配置HealthCheck，默认为 http://addr:port/health/ready
+				Tags:     []string{"dev"},
+				Weight:   100,
+				Id:       fmt.Sprintf("%s:%d", serverAddr, serverPort),
 			},
 			&config.RegisterNode{
 				//Namespace: "dilu",
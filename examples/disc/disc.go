@@ -29,7 +29,7 @@ func main() {
 				continue
 			}
 			if rs != nil {
-				slog.Info("service", "name", *rs)
+				slog.Info("service", "name", rs.RegisterNode)
 				if rs.Protocol == "http" {
 					httpPing(rs)
 				} else {
@@ -45,9 +45,10 @@ func main() {
 
 func httpPing(rs *models.ServiceNode) {
 	url := rs.GetUrl() + "/ping"
+	start := time.Now()
 	resp, err := http.Get(url)
+	rs.Report(err, time.Since(start)) //上报结果和耗时，用于摘除和异常检测
 	if err != nil {
-		rs.IncrFailCnt()
 		slog.Error("ping err", "err", err)
 		return
 	}
@@ -68,10 +69,11 @@ func grpcSayHello(rs *models.ServiceNode) {
 	}
 	c := service.NewGreeterClient(conn)
 
+	start := time.Now()
 	r, err := c.SayHello(context.Background(), &service.HelloRequest{Name: "walker"})
+	rs.Report(err, time.Since(start))
 	if err != nil {
 		slog.Error("could not greet", "err", err)
-		rs.IncrFailCnt()
 		return
 	}
 	slog.Info("Greeting: ", "msg", r.Message)
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"time"
 
 	"github.com/baowk/dilu-rd/examples/config"
@@ -16,6 +17,7 @@ import (
 	"github.com/baowk/dilu-rd/grpc/pb/health"
 	"github.com/baowk/dilu-rd/grpc/pb/service"
 	"github.com/baowk/dilu-rd/rd"
+	"github.com/baowk/dilu-rd/routing"
 
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc"
@@ -23,10 +25,7 @@ import (
 
 func main() {
 	r := gin.Default()
-	r.GET("/api/health", func(ctx *gin.Context) {
-		ctx.AbortWithStatus(http.StatusOK)
-	})
-
+	r.Use(routing.GinMiddleware())
 	r.GET("/ping", func(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, gin.H{"ping": "pong", "time": time.Now()})
 	})
@@ -42,13 +41,44 @@ func main() {
 
 	slog.Debug("rdclient:", "client", rdclient)
 
+	// 运维接口不做鉴权，只监听本机的运维端口，不挂到对外的5000端口，
+	// 如 curl -X PUT "http://127.0.0.1:5002/nodes/{id}/maintenance?reason=debug"
+	admin := &http.Server{
+		Addr:    "127.0.0.1:5002",
+		Handler: rd.AdminHandler(rdclient),
+	}
+	go func() {
+		if err := admin.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("admin listen", "err", err)
+		}
+	}()
+
+	// 健康检查接口，http服务未配置健康检查地址时注册为 /health/ready
+	r.GET("/health/*path", rd.GinHealthHandler(rdclient))
+	if rdclient != nil {
+		rdclient.AddCheck("grpc", func(ctx context.Context) error { //grpc端口不可用时两个服务都不再接收流量
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, "tcp", "127.0.0.1:5001")
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		})
+	}
+
 	go func() { //grpc服务
 		lis, err := net.Listen("tcp", ":5001")
 		if err != nil {
 			slog.Error("failed to listen", "err", err)
 		}
-		s := grpc.NewServer()
-		health.RegisterHealthServer(s, &health.HealthServerImpl{})
+		s := grpc.NewServer(grpc.ChainUnaryInterceptor(routing.UnaryServerInterceptor()),
+			grpc.ChainStreamInterceptor(routing.StreamServerInterceptor()))
+		hs := health.NewHealthServer()
+		hs.SetServingStatus("Health", health.HealthCheckResponse_SERVING) //注册时健康检查地址为 ip:5001/Health
+		health.RegisterHealthServer(s, hs)
+		if rdclient != nil {
+			rdclient.SetHealthServer(hs) //摘流时置为NOT_SERVING
+		}
 		service.RegisterGreeterServer(s, &impl.TempimplementedGreeterServer{})
 		fmt.Println("grpc server start", ips, "5001")
 		slog.Debug("grpc start:", "ip", ips, "port", 5001)
@@ -84,8 +114,24 @@ func main() {
 	defer cancel()
 	slog.Info("Shutdown Server " + time.Now().String())
 
-	rdclient.Deregister()
+	// 先摘流，等消费方不再调用本服务后再注销
+	var wg sync.WaitGroup
+	for _, rs := range cfg.Registers {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := rdclient.Drain(id, 3*time.Second); err != nil {
+				slog.Error("rdclient drain", "id", id, "err", err)
+			}
+		}(rs.Id)
+	}
+	wg.Wait()
+
+	if err := rdclient.Close(ctx); err != nil {
+		slog.Error("rdclient close", "err", err)
+	}
 
+	admin.Shutdown(ctx)
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server Shutdown:", err)
 	}
@@ -2,18 +2,141 @@ package health
 
 import (
 	"context"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// HealthServerImpl grpc健康检查服务，按服务名记录状态，空服务名表示整个服务器。
+// 零值可直接使用，初始时空服务名为SERVING
 type HealthServerImpl struct {
-	*UnimplementedHealthServer
+	UnimplementedHealthServer
+	mutex    sync.Mutex
+	shutdown bool                                                           //已关闭，不再接受状态修改
+	statuses map[string]HealthCheckResponse_ServingStatus                   //服务状态
+	watchers map[string]map[chan HealthCheckResponse_ServingStatus]struct{} //Watch中的订阅者
+}
+
+func NewHealthServer() *HealthServerImpl {
+	s := &HealthServerImpl{}
+	s.init()
+	return s
+}
+
+// init 需持有锁
+func (s *HealthServerImpl) init() {
+	if s.statuses == nil {
+		s.statuses = map[string]HealthCheckResponse_ServingStatus{"": HealthCheckResponse_SERVING}
+		s.watchers = make(map[string]map[chan HealthCheckResponse_ServingStatus]struct{})
+	}
+}
+
+func (s *HealthServerImpl) Check(ctx context.Context, in *HealthCheckRequest) (*HealthCheckResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.init()
+	st, ok := s.statuses[in.GetService()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	return &HealthCheckResponse{Status: st}, nil
 }
 
-func (s *HealthServerImpl) Check(context.Context, *HealthCheckRequest) (*HealthCheckResponse, error) {
-	return &HealthCheckResponse{Status: HealthCheckResponse_SERVING}, nil
+// Watch 先发送当前状态，之后每次状态变化时发送新状态，未注册的服务为SERVICE_UNKNOWN。
+// 客户端接收较慢时只保证收到最新状态
+func (s *HealthServerImpl) Watch(in *HealthCheckRequest, stream Health_WatchServer) error {
+	service := in.GetService()
+	ch := make(chan HealthCheckResponse_ServingStatus, 1)
+	s.mutex.Lock()
+	s.init()
+	if st, ok := s.statuses[service]; ok {
+		ch <- st
+	} else {
+		ch <- HealthCheckResponse_SERVICE_UNKNOWN
+	}
+	if s.watchers[service] == nil {
+		s.watchers[service] = make(map[chan HealthCheckResponse_ServingStatus]struct{})
+	}
+	s.watchers[service][ch] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.watchers[service], ch)
+		if len(s.watchers[service]) == 0 {
+			delete(s.watchers, service)
+		}
+		s.mutex.Unlock()
+	}()
+
+	var last HealthCheckResponse_ServingStatus = -1
+	for {
+		select {
+		case st := <-ch:
+			if st == last {
+				continue
+			}
+			if err := stream.Send(&HealthCheckResponse{Status: st}); err != nil {
+				return status.Error(codes.Canceled, "stream has ended")
+			}
+			last = st
+		case <-stream.Context().Done():
+			return status.Error(codes.Canceled, "stream has ended")
+		}
+	}
 }
 
-func (s *HealthServerImpl) Watch(*HealthCheckRequest, Health_WatchServer) error {
-	return nil
+// SetServingStatus 设置服务状态并通知Watch中的订阅者，Shutdown后调用无效
+func (s *HealthServerImpl) SetServingStatus(service string, st HealthCheckResponse_ServingStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.init()
+	if s.shutdown {
+		return
+	}
+	s.setLocked(service, st)
 }
 
-//func (HealthServerImpl) mustEmbedUnimplementedHealthServer() {}
+// ServingStatus 服务当前状态，未注册的服务返回SERVICE_UNKNOWN
+func (s *HealthServerImpl) ServingStatus(service string) HealthCheckResponse_ServingStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.init()
+	if st, ok := s.statuses[service]; ok {
+		return st
+	}
+	return HealthCheckResponse_SERVICE_UNKNOWN
+}
+
+// Shutdown 把所有服务置为NOT_SERVING并忽略之后的状态修改，用于进程退出前摘流
+func (s *HealthServerImpl) Shutdown() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.init()
+	s.shutdown = true
+	for service := range s.statuses {
+		s.setLocked(service, HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+// Resume 把所有服务置为SERVING并重新接受状态修改
+func (s *HealthServerImpl) Resume() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.init()
+	s.shutdown = false
+	for service := range s.statuses {
+		s.setLocked(service, HealthCheckResponse_SERVING)
+	}
+}
+
+func (s *HealthServerImpl) setLocked(service string, st HealthCheckResponse_ServingStatus) {
+	s.statuses[service] = st
+	for ch := range s.watchers[service] {
+		select { //丢弃未发送的旧状态，只保留最新状态
+		case <-ch:
+		default:
+		}
+		ch <- st
+	}
+}
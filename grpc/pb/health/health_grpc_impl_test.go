@@ -0,0 +1,207 @@
+package health
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serve 在内存中启动grpc服务，返回连接到该服务的客户端
+func serve(t *testing.T, s *HealthServerImpl) HealthClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 16)
+	srv := grpc.NewServer()
+	RegisterHealthServer(srv, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewHealthClient(conn)
+}
+
+func check(t *testing.T, c HealthClient, service string) HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := c.Check(context.Background(), &HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Check(%q): %v", service, err)
+	}
+	return resp.Status
+}
+
+func recv(t *testing.T, w Health_WatchClient) HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := w.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	return resp.Status
+}
+
+func TestCheck(t *testing.T) {
+	s := NewHealthServer()
+	c := serve(t, s)
+	if st := check(t, c, ""); st != HealthCheckResponse_SERVING {
+		t.Errorf("server status = %s, want SERVING", st)
+	}
+	_, err := c.Check(context.Background(), &HealthCheckRequest{Service: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Check unknown service: %v, want NotFound", err)
+	}
+	s.SetServingStatus("svc", HealthCheckResponse_NOT_SERVING)
+	if st := check(t, c, "svc"); st != HealthCheckResponse_NOT_SERVING {
+		t.Errorf("svc status = %s, want NOT_SERVING", st)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := NewHealthServer()
+	c := serve(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := c.Watch(ctx, &HealthCheckRequest{Service: "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := recv(t, w); st != HealthCheckResponse_SERVICE_UNKNOWN {
+		t.Fatalf("first status = %s, want SERVICE_UNKNOWN", st)
+	}
+	s.SetServingStatus("svc", HealthCheckResponse_SERVING)
+	s.SetServingStatus("svc", HealthCheckResponse_SERVING) //未变化不发送
+	s.SetServingStatus("svc", HealthCheckResponse_NOT_SERVING)
+	got := []HealthCheckResponse_ServingStatus{recv(t, w)}
+	if got[0] == HealthCheckResponse_SERVING {
+		got = append(got, recv(t, w))
+	}
+	if got[len(got)-1] != HealthCheckResponse_NOT_SERVING {
+		t.Errorf("statuses = %v, want SERVING then NOT_SERVING, or only the latest", got)
+	}
+
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mutex.Lock()
+		n := len(s.watchers)
+		s.mutex.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watcher not removed after the client cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// slowStream 每次Send都等待放行，模拟接收较慢的客户端
+type slowStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	sent    chan HealthCheckResponse_ServingStatus
+	release chan struct{}
+}
+
+func (s *slowStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *slowStream) Send(resp *HealthCheckResponse) error {
+	s.sent <- resp.Status
+	<-s.release
+	return nil
+}
+
+func TestWatchSlowClient(t *testing.T) {
+	s := NewHealthServer()
+	s.SetServingStatus("svc", HealthCheckResponse_SERVING)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &slowStream{ctx: ctx, sent: make(chan HealthCheckResponse_ServingStatus, 10), release: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() { done <- s.Watch(&HealthCheckRequest{Service: "svc"}, stream) }()
+
+	next := func() HealthCheckResponse_ServingStatus {
+		select {
+		case st := <-stream.sent:
+			return st
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for Send")
+		}
+		return 0
+	}
+	if st := next(); st != HealthCheckResponse_SERVING {
+		t.Fatalf("first status = %s, want SERVING", st)
+	}
+	stream.release <- struct{}{}
+	s.SetServingStatus("svc", HealthCheckResponse_NOT_SERVING)
+	if st := next(); st != HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("status = %s, want NOT_SERVING", st)
+	}
+	//发送阻塞期间的多次变化只保留最新状态
+	s.SetServingStatus("svc", HealthCheckResponse_SERVING)
+	s.SetServingStatus("svc", HealthCheckResponse_NOT_SERVING)
+	s.SetServingStatus("svc", HealthCheckResponse_SERVING)
+	stream.release <- struct{}{}
+	if st := next(); st != HealthCheckResponse_SERVING {
+		t.Fatalf("status = %s, want the latest SERVING", st)
+	}
+	stream.release <- struct{}{}
+	select {
+	case st := <-stream.sent:
+		t.Errorf("unexpected status %s, only the latest should be sent", st)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-done; status.Code(err) != codes.Canceled {
+		t.Errorf("Watch = %v, want Canceled", err)
+	}
+}
+
+func TestShutdownResume(t *testing.T) {
+	s := NewHealthServer()
+	s.SetServingStatus("svc", HealthCheckResponse_SERVING)
+	c := serve(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := c.Watch(ctx, &HealthCheckRequest{Service: "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := recv(t, w); st != HealthCheckResponse_SERVING {
+		t.Fatalf("first status = %s, want SERVING", st)
+	}
+
+	s.Shutdown()
+	if st := recv(t, w); st != HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status after Shutdown = %s, want NOT_SERVING", st)
+	}
+	for _, service := range []string{"", "svc"} {
+		if st := check(t, c, service); st != HealthCheckResponse_NOT_SERVING {
+			t.Errorf("%q after Shutdown = %s, want NOT_SERVING", service, st)
+		}
+	}
+	s.SetServingStatus("svc", HealthCheckResponse_SERVING) //Shutdown后忽略
+	if st := check(t, c, "svc"); st != HealthCheckResponse_NOT_SERVING {
+		t.Errorf("SetServingStatus after Shutdown changed status to %s", st)
+	}
+
+	s.Resume()
+	if st := recv(t, w); st != HealthCheckResponse_SERVING {
+		t.Errorf("status after Resume = %s, want SERVING", st)
+	}
+	s.SetServingStatus("svc", HealthCheckResponse_NOT_SERVING)
+	if st := check(t, c, "svc"); st != HealthCheckResponse_NOT_SERVING {
+		t.Errorf("SetServingStatus after Resume was ignored: %s", st)
+	}
+}
@@ -1,62 +1,345 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"sync"
+	"time"
 
+	"github.com/baowk/dilu-rd/breaker"
 	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/routing"
 	"google.golang.org/grpc"
 )
 
+// ErrNodeClosed 节点已被移除
+var ErrNodeClosed = errors.New("service node closed")
+
 type ServiceNode struct {
 	config.RegisterNode                  //注册节点
-	Weight              int              //权重
+	Weight              int              //当前权重，注册权重见RegisterNode.Weight
+	mutex               sync.Mutex       //保护下面的状态，调用方可在多个协程中上报失败
 	failCnt             int              //失败次数
 	enable              bool             //是否启用
-	grpc                *grpc.ClientConn //grpc连接
+	ejectedAt           time.Time        //因失败次数超限被摘除的时间，零值表示未被摘除
+	ejections           int              //连续被摘除的次数
+	resetAt             time.Time        //重新启用后保持正常到该时间，连续摘除次数清零
+	breaker             *breaker.Breaker //熔断器，为nil时不熔断
+	stats               Stats            //上次异常检测以来的调用统计
+	outlierUntil        time.Time        //作为异常节点被摘除到该时间
+	outlierEjections    int              //作为异常节点被摘除的次数，正常时逐步减少
+	unhealthy           bool             //主动健康检查不通过
+	probeStreak         int              //主动健康检查连续成功(正数)或失败(负数)的次数
+	grpc                *grpc.ClientConn //grpc连接，由mutex保护
+	closed              bool             //已关闭，不再建立grpc连接
 }
 
 func (n *ServiceNode) Enable() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
 	return n.enable
 }
 
+// SetEnable 启用或禁用节点，同时清除摘除状态
 func (n *ServiceNode) SetEnable(enable bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
 	n.enable = enable
+	n.ejectedAt = time.Time{}
+}
+
+// Renew 返回使用新注册信息的节点，失败计数、摘除、熔断、健康检查状态和grpc连接沿用n。
+// 已返回给调用方的节点不再修改注册信息，发现方有变化时用返回的节点替换n，n不需要Close
+func (n *ServiceNode) Renew(rn config.RegisterNode, weight int) *ServiceNode {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return &ServiceNode{
+		RegisterNode:     rn,
+		Weight:           weight,
+		failCnt:          n.failCnt,
+		enable:           n.enable,
+		ejectedAt:        n.ejectedAt,
+		ejections:        n.ejections,
+		resetAt:          n.resetAt,
+		breaker:          n.breaker,
+		stats:            n.stats,
+		outlierUntil:     n.outlierUntil,
+		outlierEjections: n.outlierEjections,
+		unhealthy:        n.unhealthy,
+		probeStreak:      n.probeStreak,
+		grpc:             n.grpc,
+		closed:           n.closed,
+	}
+}
+
+// Stats 调用统计
+type Stats struct {
+	Success int           //成功次数
+	Failure int           //失败次数
+	Latency time.Duration //上报了耗时的调用的总耗时
+	Timed   int           //上报了耗时的调用次数
+}
+
+// Available 节点是否可被调度：已启用、主动健康检查通过、不在摘流、维护或不健康状态、未熔断且未被异常检测摘除
+func (n *ServiceNode) Available() bool {
+	n.mutex.Lock()
+	ok := n.enable && !n.unhealthy && !time.Now().Before(n.outlierUntil)
+	b := n.breaker
+	n.mutex.Unlock()
+	return ok && n.Status != config.StatusDraining && n.Status != config.StatusMaintenance && n.Status != config.StatusUnhealthy && b.Ready()
+}
+
+// SetBreaker 设置节点的熔断器，发现方按DiscoveryNode.CircuitBreaker创建
+func (n *ServiceNode) SetBreaker(b *breaker.Breaker) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.breaker = b
+}
+
+func (n *ServiceNode) getBreaker() *breaker.Breaker {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.breaker
+}
+
+// BreakerState 熔断器状态，未配置熔断时始终为closed
+func (n *ServiceNode) BreakerState() breaker.State {
+	return n.getBreaker().State()
+}
+
+// Acquire 节点被调度选中时调用，熔断器半开时占用一个探测名额
+func (n *ServiceNode) Acquire() {
+	n.getBreaker().Acquire()
+}
+
+// ReportSuccess 上报一次成功调用，清除失败次数并计入熔断统计
+func (n *ServiceNode) ReportSuccess() {
+	n.mutex.Lock()
+	n.failCnt = 0
+	n.stats.Success++
+	n.decayEjections(time.Now())
+	b := n.breaker
+	n.mutex.Unlock()
+	b.Success()
+}
+
+// Report 上报一次调用的结果和耗时，err为nil表示成功，耗时用于异常节点检测
+func (n *ServiceNode) Report(err error, latency time.Duration) {
+	n.mutex.Lock()
+	n.stats.Latency += latency
+	n.stats.Timed++
+	n.mutex.Unlock()
+	if err != nil {
+		n.IncrFailCnt()
+	} else {
+		n.ReportSuccess()
+	}
+}
+
+// TakeStats 返回上次调用以来的调用统计并清零
+func (n *ServiceNode) TakeStats() Stats {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	st := n.stats
+	n.stats = Stats{}
+	return st
+}
+
+// EjectOutlier 作为异常节点摘除，摘除时间为base乘以累计摘除次数
+func (n *ServiceNode) EjectOutlier(base time.Duration) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.outlierEjections++
+	n.outlierUntil = time.Now().Add(base * time.Duration(n.outlierEjections))
+}
+
+// OutlierEjected 是否正作为异常节点被摘除
+func (n *ServiceNode) OutlierEjected() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return time.Now().Before(n.outlierUntil)
+}
+
+// Healthy 主动健康检查是否通过，未开启检查时始终为true
+func (n *ServiceNode) Healthy() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return !n.unhealthy
+}
+
+// ReportProbe 记录一次主动健康检查结果，连续失败unhealthyThreshold次后标记为不健康，
+// 不健康时连续成功healthyThreshold次后恢复，返回健康状态是否变化
+func (n *ServiceNode) ReportProbe(ok bool, healthyThreshold, unhealthyThreshold int) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if ok {
+		if n.probeStreak < 0 {
+			n.probeStreak = 0
+		}
+		n.probeStreak++
+		if n.unhealthy && n.probeStreak >= healthyThreshold {
+			n.unhealthy = false
+			return true
+		}
+		return false
+	}
+	if n.probeStreak > 0 {
+		n.probeStreak = 0
+	}
+	n.probeStreak--
+	if !n.unhealthy && -n.probeStreak >= unhealthyThreshold {
+		n.unhealthy = true
+		return true
+	}
+	return false
 }
 
+// DecayOutlier 检测正常时减少累计摘除次数，下次被摘除的时间随之缩短
+func (n *ServiceNode) DecayOutlier() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.outlierEjections > 0 && !time.Now().Before(n.outlierUntil) {
+		n.outlierEjections--
+	}
+}
+
+// ClearFailCnt 清除失败次数和连续摘除次数
 func (n *ServiceNode) ClearFailCnt() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
 	n.failCnt = 0
+	n.ejections = 0
+	n.resetAt = time.Time{}
+}
+
+// decayEjections 重新启用后已正常运行一段时间，之前的摘除不再计入连续摘除次数，需持有mutex
+func (n *ServiceNode) decayEjections(now time.Time) {
+	if !n.resetAt.IsZero() && !now.Before(n.resetAt) {
+		n.ejections = 0
+		n.resetAt = time.Time{}
+	}
 }
 
+// IncrFailCnt 上报一次失败调用，计入熔断统计；连续失败次数超过FailLimit时摘除节点，由发现方在重试时间后探测恢复
 func (n *ServiceNode) IncrFailCnt() {
+	n.mutex.Lock()
 	n.failCnt++
-	if n.failCnt > n.FailLimit {
+	n.stats.Failure++
+	if n.enable && n.failCnt > n.FailLimit {
+		now := time.Now()
+		n.decayEjections(now)
 		n.enable = false
+		n.ejectedAt = now
+		n.ejections++
+		n.resetAt = time.Time{}
 	}
+	b := n.breaker
+	n.mutex.Unlock()
+	b.Failure()
 }
 
 func (n *ServiceNode) GetFailCnt() int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
 	return n.failCnt
 }
 
+// Ejected 节点是否因失败被摘除，返回摘除时间和连续摘除次数
+func (n *ServiceNode) Ejected() (at time.Time, ejections int, ok bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.ejectedAt, n.ejections, !n.ejectedAt.IsZero()
+}
+
+// Readmit 探测成功后重新启用被摘除的节点。healthy时间内再次被摘除时连续摘除次数累加，等待更久；
+// 正常运行超过healthy后连续摘除次数清零
+func (n *ServiceNode) Readmit(healthy time.Duration) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.ejectedAt.IsZero() {
+		return
+	}
+	n.enable = true
+	n.failCnt = 0
+	n.ejectedAt = time.Time{}
+	n.resetAt = time.Now().Add(healthy)
+}
+
+// Reject 探测失败，重新开始计时并增加连续摘除次数
+func (n *ServiceNode) Reject() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.ejectedAt.IsZero() {
+		return
+	}
+	n.ejectedAt = time.Now()
+	n.ejections++
+}
+
+// GetMetadata 获取注册时携带的元数据
+func (n *ServiceNode) GetMetadata(key string) string {
+	return n.Metadata[key]
+}
+
+// Label 按键查找元数据，用于选择表达式
+func (n *ServiceNode) Label(key string) (string, bool) {
+	v, ok := n.Metadata[key]
+	return v, ok
+}
+
+// HasTag 节点是否带有指定标签
+func (n *ServiceNode) HasTag(tag string) bool {
+	for _, t := range n.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchMetadata 节点元数据是否包含md中的全部键值
+func (n *ServiceNode) MatchMetadata(md map[string]string) bool {
+	return MatchMetadata(n.Metadata, md)
+}
+
+// MatchMetadata meta是否包含md中的全部键值
+func MatchMetadata(meta, md map[string]string) bool {
+	for k, v := range md {
+		if val, ok := meta[k]; !ok || val != v {
+			return false
+		}
+	}
+	return true
+}
+
 func (n *ServiceNode) GetUrl() string {
 	return fmt.Sprintf("%s://%s:%d", n.Protocol, n.Addr, n.Port)
 }
 
-// func (n *ServiceNode) GetHttpClient() (*http.Client, error) {
-// 	//http.NewClient(fmt.Sprintf("%s://%s:%d", n.Protocol, n.Addr, n.Port))
-// 	return nil, nil
-// }
+// GetHttpClient 返回的客户端会把请求ctx中的路由提示传给下游
+func (n *ServiceNode) GetHttpClient() *http.Client {
+	return &http.Client{Transport: routing.NewTransport(nil)}
+}
 
-func (n *ServiceNode) GetGrpcConn() (conn *grpc.ClientConn, err error) {
+// GetGrpcConn 返回节点的grpc连接，首次调用时建立，节点被移除(Close)后返回ErrNodeClosed
+func (n *ServiceNode) GetGrpcConn() (*grpc.ClientConn, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.closed {
+		return nil, ErrNodeClosed
+	}
 	if n.grpc != nil {
-		conn = n.grpc
-	} else {
-		conn, err = grpc.Dial(fmt.Sprintf("%s:%d", n.Addr, n.Port), grpc.WithInsecure())
-		if err == nil {
-			n.grpc = conn
-		}
+		return n.grpc, nil
 	}
-	return
+	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", n.Addr, n.Port), grpc.WithInsecure(), //不阻塞，实际连接在首次调用时建立
+		grpc.WithChainUnaryInterceptor(routing.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(routing.StreamClientInterceptor()))
+	if err != nil {
+		return nil, err
+	}
+	n.grpc = conn
+	return conn, nil
 }
 
 // func (n *ServiceNode) GetRpcConn() (*rpc.Client, error) {
@@ -68,8 +351,14 @@ func (n *ServiceNode) GetGrpcConn() (conn *grpc.ClientConn, err error) {
 // }
 
 func (n *ServiceNode) Close() {
+	n.mutex.Lock()
 	n.enable = false
-	if n.grpc != nil {
-		n.grpc.Close()
+	n.ejectedAt = time.Time{}
+	n.closed = true
+	conn := n.grpc
+	n.grpc = nil
+	n.mutex.Unlock()
+	if conn != nil {
+		conn.Close()
 	}
 }
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func eject(n *ServiceNode) int {
+	for i := 0; i <= n.FailLimit; i++ {
+		n.IncrFailCnt()
+	}
+	_, ejections, _ := n.Ejected()
+	return ejections
+}
+
+func TestEjectionsReset(t *testing.T) {
+	n := &ServiceNode{}
+	n.FailLimit = 1
+	n.SetEnable(true)
+
+	if got := eject(n); got != 1 {
+		t.Fatalf("ejections = %d, want 1", got)
+	}
+	n.Readmit(time.Hour)
+	if got := eject(n); got != 2 {
+		t.Fatalf("ejected again within the healthy period: ejections = %d, want 2", got)
+	}
+
+	n.Readmit(0)
+	n.ReportSuccess()
+	if _, ejections, _ := n.Ejected(); ejections != 0 {
+		t.Errorf("success after the healthy period: ejections = %d, want 0", ejections)
+	}
+	if got := eject(n); got != 1 {
+		t.Errorf("ejections = %d, want backoff to restart at 1", got)
+	}
+
+	n.Readmit(0)
+	if got := eject(n); got != 1 {
+		t.Errorf("ejected after the healthy period without success: ejections = %d, want 1", got)
+	}
+}
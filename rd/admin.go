@@ -0,0 +1,62 @@
+package rd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/driver"
+)
+
+// Maintainer 查看已注册节点和切换维护状态，RDClient和RDClientV2都实现了该接口
+type Maintainer interface {
+	RegisteredNodes() []*config.RegisterNode
+	SetMaintenanceContext(ctx context.Context, id string, enable bool, reason string) error
+}
+
+// AdminHandler 运维接口，用于查看已注册节点和切换维护状态，不做鉴权，只应在内网端口暴露：
+//
+//	GET    /nodes                                 已注册节点及其状态
+//	PUT    /nodes/{id}/maintenance?reason=debug   进入维护，消费方不再调度到该节点
+//	DELETE /nodes/{id}/maintenance                退出维护
+//
+// 挂载到子路径时使用http.StripPrefix，如 mux.Handle("/rd/", http.StripPrefix("/rd", rd.AdminHandler(client)))
+func AdminHandler(c Maintainer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /nodes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.RegisteredNodes())
+	})
+	mux.HandleFunc("PUT /nodes/{id}/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		setMaintenance(w, r, c, r.PathValue("id"), true, r.URL.Query().Get("reason"))
+	})
+	mux.HandleFunc("DELETE /nodes/{id}/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		setMaintenance(w, r, c, r.PathValue("id"), false, "")
+	})
+	return mux
+}
+
+func setMaintenance(w http.ResponseWriter, r *http.Request, c Maintainer, id string, enable bool, reason string) {
+	if err := c.SetMaintenanceContext(r.Context(), id, enable, reason); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, driver.ErrNotRegistered) {
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	for _, n := range c.RegisteredNodes() {
+		if n.Id == id {
+			writeJSON(w, http.StatusOK, n)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,52 @@
+package rd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/baowk/dilu-rd/config"
+)
+
+var (
+	_ Maintainer = RDClient(nil)
+	_ Maintainer = RDClientV2(nil)
+)
+
+func TestAdminHandler(t *testing.T) {
+	srv := fakeAgent()
+	defer srv.Close()
+	ctx := context.Background()
+	c, err := NewRDClientV2(ctx, &config.Config{Driver: "consul", Endpoints: []string{strings.TrimPrefix(srv.URL, "http://")}, Scheme: "http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx)
+	if err := c.Register(ctx, &config.RegisterNode{Name: "api", Addr: "127.0.0.1", Port: 8080, Protocol: "http"}); err != nil {
+		t.Fatal(err)
+	}
+	h := AdminHandler(c)
+	do := func(method, path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+
+	if code := do(http.MethodPut, "/nodes/127.0.0.1:8080/maintenance?reason=debug"); code != http.StatusOK {
+		t.Fatalf("PUT maintenance = %d, want 200", code)
+	}
+	if n := c.RegisteredNodes()[0]; n.Status != config.StatusMaintenance || n.Metadata[config.MetaMaintenanceReason] != "debug" {
+		t.Errorf("status = %q reason = %q, want maintenance", n.Status, n.Metadata[config.MetaMaintenanceReason])
+	}
+	if code := do(http.MethodDelete, "/nodes/127.0.0.1:8080/maintenance"); code != http.StatusOK {
+		t.Fatalf("DELETE maintenance = %d, want 200", code)
+	}
+	if n := c.RegisteredNodes()[0]; n.Status != "" {
+		t.Errorf("status = %q after leaving maintenance", n.Status)
+	}
+	if code := do(http.MethodPut, "/nodes/unknown/maintenance"); code != http.StatusNotFound {
+		t.Errorf("PUT unknown node = %d, want 404", code)
+	}
+}
@@ -0,0 +1,172 @@
+package rd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/driver"
+	"github.com/baowk/dilu-rd/grpc/pb/health"
+)
+
+// CheckFunc 本地就绪检查，返回error表示未就绪，如数据库不可用
+type CheckFunc func(ctx context.Context) error
+
+const checkInterval = config.DefaultInterval //就绪检查间隔，同时是单次检查的超时时间
+
+const (
+	CheckPassing = "passing"
+	CheckFailing = "failing"
+	CheckPending = "pending" //添加后尚未执行
+)
+
+// CheckResult 就绪检查结果
+type CheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AddCheck 添加或替换命名的就绪检查。任一检查失败时，已注册的节点在注册中心被标记为不健康(unhealthy)，
+// 消费方不再调度到这些节点，consul ttl模式的检查上报critical，grpc健康检查服务置为NOT_SERVING；
+// 全部检查通过后自动恢复。检查在后台每5秒执行一次，Close时停止
+func (c *rdClient) AddCheck(name string, fn CheckFunc) error {
+	c.mutex.Lock()
+	c.checks[name] = fn
+	c.mutex.Unlock()
+	var err error
+	c.checkOnce.Do(func() {
+		err = c.Go(c.runChecks)
+	})
+	return err
+}
+
+// RemoveCheck 移除就绪检查，下次检查时按剩余的检查结果更新节点状态
+func (c *rdClient) RemoveCheck(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.checks, name)
+	delete(c.results, name)
+}
+
+func (c *rdClient) runChecks(ctx context.Context) {
+	for {
+		c.mutex.Lock()
+		checks := make(map[string]CheckFunc, len(c.checks))
+		for name, fn := range c.checks {
+			checks[name] = fn
+		}
+		c.mutex.Unlock()
+
+		results := make(map[string]error, len(checks))
+		var mutex sync.Mutex
+		var wg sync.WaitGroup
+		for name, fn := range checks {
+			wg.Add(1)
+			go func(name string, fn CheckFunc) {
+				defer wg.Done()
+				cctx, cancel := context.WithTimeout(ctx, checkInterval)
+				defer cancel()
+				err := fn(cctx)
+				mutex.Lock()
+				results[name] = err
+				mutex.Unlock()
+			}(name, fn)
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		c.mutex.Lock()
+		for name, err := range results {
+			if _, ok := c.checks[name]; ok { //检查期间可能已被移除
+				c.results[name] = err
+			}
+		}
+		reason := failedReason(c.results)
+		c.mutex.Unlock()
+		c.syncHealth(ctx, reason)
+
+		if !driver.Sleep(ctx, checkInterval) {
+			return
+		}
+	}
+}
+
+// CheckResults 各就绪检查最近一次的结果，按名称排序，尚未执行的检查Status为pending
+func (c *rdClient) CheckResults() []CheckResult {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	rs := make([]CheckResult, 0, len(c.checks))
+	for name := range c.checks {
+		r := CheckResult{Name: name, Status: CheckPending}
+		if err, ok := c.results[name]; ok {
+			r.Status = CheckPassing
+			if err != nil {
+				r.Status, r.Error = CheckFailing, err.Error()
+			}
+		}
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].Name < rs[j].Name
+	})
+	return rs
+}
+
+// failedReason 失败检查的说明，全部通过时为空
+func failedReason(results map[string]error) string {
+	var failed []string
+	for name, err := range results {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	sort.Strings(failed)
+	return strings.Join(failed, "; ")
+}
+
+// syncHealth 按检查结果更新已注册节点的状态，失败原因只在状态变化时记录。
+// 每次检查都重新比对，检查失败期间新注册的节点也会被标记
+func (c *rdClient) syncHealth(ctx context.Context, reason string) {
+	unhealthy := reason != ""
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+	for _, node := range c.RegisteredNodes() {
+		old := node.Status
+		driver.SetUnhealthy(node, unhealthy, reason)
+		if node.Status == old { //状态未变，或处于摘流、维护中
+			continue
+		}
+		if unhealthy {
+			slog.Warn("readiness failed", "id", node.Id, "reason", reason)
+		} else {
+			slog.Info("readiness recovered", "id", node.Id)
+		}
+		if err := c.driverClient.UpdateContext(ctx, node); err != nil {
+			slog.Error("readiness update", "id", node.Id, "err", err)
+			continue
+		}
+		c.setServing(node, !unhealthy)
+	}
+}
+
+// setServing 同步设置grpc节点的健康检查服务状态
+func (c *rdClient) setServing(node *config.RegisterNode, serving bool) {
+	c.mutex.Lock()
+	h := c.health
+	c.mutex.Unlock()
+	if h == nil || node.Protocol != "grpc" {
+		return
+	}
+	status := health.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = health.HealthCheckResponse_SERVING
+	}
+	h.SetServingStatus(grpcHealthService(node), status)
+}
@@ -0,0 +1,121 @@
+package rd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeAgent 本地的consul agent替身，记录注册的服务并在健康查询中全部返回passing
+func fakeAgent() *httptest.Server {
+	var mutex sync.Mutex
+	regs := make(map[string]*api.AgentServiceRegistration)
+	var index uint64 = 1
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch {
+		case r.URL.Path == "/v1/agent/service/register":
+			var reg api.AgentServiceRegistration
+			json.NewDecoder(r.Body).Decode(&reg)
+			regs[reg.ID] = &reg
+			index++
+		case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+			delete(regs, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+			index++
+		case strings.HasPrefix(r.URL.Path, "/v1/health/service/"):
+			name := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+			entries := []*api.ServiceEntry{}
+			for _, reg := range regs {
+				if reg.Name == name {
+					entries = append(entries, &api.ServiceEntry{
+						Service: &api.AgentService{ID: reg.ID, Service: reg.Name, Address: reg.Address, Port: reg.Port, Meta: reg.Meta},
+						Checks:  api.HealthChecks{{Status: api.HealthPassing}},
+					})
+				}
+			}
+			w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+			json.NewEncoder(w).Encode(entries)
+		default:
+			w.Write([]byte("{}"))
+		}
+	}))
+}
+
+// waitGoroutines 等待协程数回落到baseline，超时时输出全部协程的栈
+func waitGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			var sb strings.Builder
+			pprof.Lookup("goroutine").WriteTo(&sb, 1)
+			t.Fatalf("%d goroutines left running, baseline %d:\n%s", runtime.NumGoroutine(), baseline, sb.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCloseLeavesNothingRunning(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	srv := fakeAgent()
+	cfg := &config.Config{
+		Driver:    "consul",
+		Endpoints: []string{strings.TrimPrefix(srv.URL, "http://")},
+		Scheme:    "http",
+		Registers: []*config.RegisterNode{
+			{Name: "api", Addr: "127.0.0.1", Port: 18080, Protocol: "http", CheckMode: config.CheckModeTTL, Interval: 10 * time.Millisecond},
+			{Name: "rpc", Addr: "127.0.0.1", Port: 18081, Protocol: "grpc", CheckMode: config.CheckModeTTL, Interval: 10 * time.Millisecond},
+		},
+	}
+	ctx := context.Background()
+	c, err := NewRDClientV2(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"api", "rpc"} {
+		err := c.Watch(ctx, &config.DiscoveryNode{
+			Enable:           true,
+			Name:             name,
+			MinInterval:      10 * time.Millisecond,
+			HealthCheck:      &config.ActiveHealthCheck{Interval: 20 * time.Millisecond},
+			OutlierDetection: &config.OutlierDetection{Interval: 20 * time.Millisecond},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.AddCheck("db", func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.GetService(ctx, "rpc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.GetGrpcConn(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) //让后台协程都运行起来
+
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.GetGrpcConn(); err == nil {
+		t.Error("GetGrpcConn after Close should fail")
+	}
+	srv.Close()
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	waitGoroutines(t, baseline)
+}
@@ -0,0 +1,32 @@
+package rd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/baowk/dilu-rd/driver/consul"
+)
+
+// consulDriver consul驱动特有的方法
+type consulDriver interface {
+	SetHealthFunc(fn consul.HealthFunc)
+	ActiveEndpoint() string
+}
+
+// SetHealthFunc 设置consul ttl模式的本地健康检测，见consul.ConsulClient.SetHealthFunc，etcd驱动返回errors.ErrUnsupported
+func (c *rdClient) SetHealthFunc(fn consul.HealthFunc) error {
+	d, ok := c.driverClient.(consulDriver)
+	if !ok {
+		return fmt.Errorf("set health func: %w", errors.ErrUnsupported)
+	}
+	d.SetHealthFunc(fn)
+	return nil
+}
+
+// ActiveEndpoint consul驱动当前使用的agent地址，etcd驱动返回空字符串
+func (c *rdClient) ActiveEndpoint() string {
+	if d, ok := c.driverClient.(consulDriver); ok {
+		return d.ActiveEndpoint()
+	}
+	return ""
+}
@@ -0,0 +1,27 @@
+package rd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/baowk/dilu-rd/config"
+)
+
+func TestConsulDriverMethods(t *testing.T) {
+	srv := fakeAgent()
+	defer srv.Close()
+	ctx := context.Background()
+	endpoint := strings.TrimPrefix(srv.URL, "http://")
+	c, err := NewRDClient(&config.Config{Driver: "consul", Endpoints: []string{endpoint}, Scheme: "http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx)
+	if got := c.ActiveEndpoint(); got != endpoint {
+		t.Errorf("ActiveEndpoint() = %q, want %q", got, endpoint)
+	}
+	if err := c.SetHealthFunc(func(s *config.RegisterNode) (string, string) { return "passing", "" }); err != nil {
+		t.Errorf("SetHealthFunc: %v", err)
+	}
+}
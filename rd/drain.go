@@ -0,0 +1,71 @@
+package rd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/driver"
+	"github.com/baowk/dilu-rd/grpc/pb/health"
+)
+
+// HealthServer 摘流时同步设置状态的grpc健康检查服务，*health.HealthServerImpl实现了该接口
+type HealthServer interface {
+	SetServingStatus(service string, status health.HealthCheckResponse_ServingStatus)
+}
+
+// SetHealthServer 设置本进程的grpc健康检查服务，grpc节点摘流时将其健康检查的服务置为NOT_SERVING，
+// 注册中心的grpc检查和消费方的主动健康检查随之失败
+func (c *rdClient) SetHealthServer(h HealthServer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.health = h
+}
+
+// grpcHealthService 节点健康检查的grpc服务名，HealthCheck格式为 地址:端口/服务名，没有服务名时为整个服务器
+func grpcHealthService(node *config.RegisterNode) string {
+	_, service, _ := strings.Cut(node.HealthCheck, "/")
+	return service
+}
+
+func (c *rdClient) SetMaintenance(id string, enable bool, reason string) error {
+	return c.SetMaintenanceContext(context.Background(), id, enable, reason)
+}
+
+// SetMaintenanceContext 设置或取消节点的维护状态，与摘流、就绪检查互斥，避免读取节点后被对方覆盖
+func (c *rdClient) SetMaintenanceContext(ctx context.Context, id string, enable bool, reason string) error {
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+	return c.driverClient.SetMaintenanceContext(ctx, id, enable, reason)
+}
+
+func (c *rdClient) Drain(id string, d time.Duration) error {
+	return c.DrainContext(context.Background(), id, d)
+}
+
+// DrainContext 摘流后注销节点：先在注册中心把节点标记为draining，消费方感知后不再调度到该节点，
+// 等待d让消费方更新节点列表、已在处理的请求完成，再注销节点。
+// ctx结束时不再等待，仍会注销节点
+func (c *rdClient) DrainContext(ctx context.Context, id string, d time.Duration) error {
+	c.statusMutex.Lock()
+	node, ok := c.RegisteredNode(id)
+	if !ok {
+		c.statusMutex.Unlock()
+		return driver.ErrNotRegistered
+	}
+	node.Status = config.StatusDraining
+	err := c.driverClient.UpdateContext(ctx, node)
+	c.statusMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	h := c.health
+	c.mutex.Unlock()
+	if h != nil && node.Protocol == "grpc" {
+		h.SetServingStatus(grpcHealthService(node), health.HealthCheckResponse_NOT_SERVING)
+	}
+	driver.Sleep(ctx, d)
+	return c.DeregisterNodeContext(context.WithoutCancel(ctx), id)
+}
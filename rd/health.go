@@ -0,0 +1,57 @@
+package rd
+
+import (
+	"net/http"
+
+	"github.com/baowk/dilu-rd/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Checker 提供就绪检查结果，RDClient和RDClientV2都实现了该接口
+type Checker interface {
+	CheckResults() []CheckResult
+}
+
+// HealthStatus 健康检查接口的返回内容
+type HealthStatus struct {
+	Status string        `json:"status"` //up 或 down
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+// HealthHandler 健康检查接口，结果来自AddCheck添加的就绪检查：
+//
+//	GET /health        同 /health/ready，/health/ 相同
+//	GET /health/live   存活检查，进程能处理请求即返回200
+//	GET /health/ready  就绪检查，全部检查通过返回200，否则返回503，返回各检查的结果
+//
+// http服务未配置健康检查地址时，注册的地址默认为 /health/ready，如 http.Handle("/health/", rd.HealthHandler(client))
+func HealthHandler(c Checker) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+config.DefaultHealthPath+"/live", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, &HealthStatus{Status: "up"})
+	})
+	ready := func(w http.ResponseWriter, r *http.Request) {
+		hs := &HealthStatus{Status: "up", Checks: c.CheckResults()}
+		status := http.StatusOK
+		for _, cr := range hs.Checks {
+			if cr.Status != CheckPassing {
+				hs.Status = "down"
+				status = http.StatusServiceUnavailable
+			}
+		}
+		writeJSON(w, status, hs)
+	}
+	mux.HandleFunc("GET "+config.DefaultHealthPath+"/ready", ready)
+	mux.HandleFunc("GET "+config.DefaultHealthPath, ready)
+	mux.HandleFunc("GET "+config.DefaultHealthPath+"/{$}", ready)
+	return mux
+}
+
+// GinHealthHandler HealthHandler的gin适配，如 r.GET("/health/*path", rd.GinHealthHandler(client))
+func GinHealthHandler(c Checker) gin.HandlerFunc {
+	h := HealthHandler(c)
+	return func(ctx *gin.Context) {
+		h.ServeHTTP(ctx.Writer, ctx.Request)
+	}
+}
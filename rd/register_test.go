@@ -0,0 +1,48 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/baowk/dilu-rd/config"
+)
+
+func TestRegisterValidatesAndFillsDefaults(t *testing.T) {
+	srv := fakeAgent()
+	defer srv.Close()
+	ctx := context.Background()
+	c, err := NewRDClientV2(ctx, &config.Config{Driver: "consul", Endpoints: []string{strings.TrimPrefix(srv.URL, "http://")}, Scheme: "http", Zone: "z1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx)
+
+	err = c.Register(ctx, &config.RegisterNode{Name: "api", Addr: "127.0.0.1", Protocol: "http"})
+	var fe *config.FieldError
+	if !errors.As(err, &fe) || fe.Field != "register.port" {
+		t.Fatalf("Register without port: %v, want register.port field error", err)
+	}
+
+	s := &config.RegisterNode{Name: "api", Addr: "127.0.0.1", Port: 8080, Protocol: "http"}
+	if err := c.Register(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Id != "" || s.Interval != 0 {
+		t.Error("Register should not modify the caller's node")
+	}
+	nodes := c.RegisteredNodes()
+	if len(nodes) != 1 {
+		t.Fatalf("registered %d nodes, want 1", len(nodes))
+	}
+	n := nodes[0]
+	if n.Id != "127.0.0.1:8080" || n.Interval != config.DefaultInterval || n.Timeout != config.DefaultTimeout || n.Zone != "z1" || n.Weight != config.DefaultWeight {
+		t.Errorf("defaults not filled: id=%s interval=%s timeout=%s zone=%s weight=%d", n.Id, n.Interval, n.Timeout, n.Zone, n.Weight)
+	}
+
+	n.Interval = n.Timeout
+	if err := c.Update(ctx, n); !errors.As(err, &fe) || fe.Field != "register.timeout" {
+		t.Errorf("Update with interval == timeout: %v, want register.timeout field error", err)
+	}
+}
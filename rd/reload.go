@@ -0,0 +1,216 @@
+package rd
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/baowk/dilu-rd/config"
+)
+
+// rdClient 在驱动之上记录由配置注册和监听的服务，Reload时与新配置比较差异后增量生效。
+// 直接调用Register、Watch添加的服务不受Reload影响
+type rdClient struct {
+	driverClient
+	mutex       sync.Mutex
+	conn        *config.Config                   //连接相关的配置，运行时不可修改
+	registers   map[string]*config.RegisterNode  //由配置注册的节点，key为节点id
+	discoveries map[string]*config.DiscoveryNode //由配置监听的服务，key为服务名
+	health      HealthServer                     //grpc健康检查服务，摘流时置为NOT_SERVING
+	checks      map[string]CheckFunc             //就绪检查
+	results     map[string]error                 //就绪检查最近一次的结果
+	checkOnce   sync.Once                        //首次添加检查时启动检查协程
+	statusMutex sync.Mutex                       //修改节点状态(摘流、维护、就绪检查)和注册时持有，避免互相覆盖
+}
+
+func newRDClient(dc driverClient, cfg *config.Config) *rdClient {
+	return &rdClient{
+		driverClient: dc,
+		conn:         connection(cfg),
+		registers:    make(map[string]*config.RegisterNode),
+		discoveries:  make(map[string]*config.DiscoveryNode),
+		checks:       make(map[string]CheckFunc),
+		results:      make(map[string]error),
+	}
+}
+
+// connection 去掉注册和发现配置，只保留连接相关的配置
+func connection(cfg *config.Config) *config.Config {
+	c := *cfg
+	c.Enable = false
+	c.Registers = nil
+	c.Discoveries = nil
+	return &c
+}
+
+func (c *rdClient) Register(s *config.RegisterNode) error {
+	return c.RegisterContext(context.Background(), s)
+}
+
+// RegisterContext 填充默认值并校验后注册，校验失败返回config.ValidationError，可用errors.As取出*config.FieldError。
+// 节点已注册时沿用其状态(摘流、维护、不健康)及其原因，状态只能通过SetMaintenance、Drain和就绪检查修改
+func (c *rdClient) RegisterContext(ctx context.Context, s *config.RegisterNode) error {
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+	return c.register(ctx, s)
+}
+
+func (c *rdClient) Update(s *config.RegisterNode) error {
+	return c.UpdateContext(context.Background(), s)
+}
+
+// UpdateContext 填充默认值并校验后更新已注册的节点，节点状态的处理同RegisterContext
+func (c *rdClient) UpdateContext(ctx context.Context, s *config.RegisterNode) error {
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+	n, err := c.prepare(s)
+	if err != nil {
+		return err
+	}
+	return c.driverClient.UpdateContext(ctx, n)
+}
+
+// register 准备节点后注册，需持有statusMutex
+func (c *rdClient) register(ctx context.Context, s *config.RegisterNode) error {
+	n, err := c.prepare(s)
+	if err != nil {
+		return err
+	}
+	return c.driverClient.RegisterContext(ctx, n)
+}
+
+// prepare 返回填充了默认值的副本并校验，与配置中的注册节点使用相同的规则；
+// 节点已注册时沿用已注册节点的状态，需持有statusMutex
+func (c *rdClient) prepare(s *config.RegisterNode) (*config.RegisterNode, error) {
+	if s == nil {
+		return nil, config.ValidationError{{Field: "register", Msg: "is nil"}}
+	}
+	n := config.RegisterWithDefaults(s, c.conn.Region, c.conn.Zone)
+	if err := config.ValidateRegister(n); err != nil {
+		return nil, err
+	}
+	if cur, ok := c.RegisteredNode(n.Id); ok {
+		n = keepStatus(n, cur)
+	}
+	return n, nil
+}
+
+func (c *rdClient) Deregister() {
+	c.DeregisterContext(context.Background())
+}
+
+// DeregisterContext 注销全部节点，下次Reload会按配置重新注册
+func (c *rdClient) DeregisterContext(ctx context.Context) error {
+	c.mutex.Lock()
+	clear(c.registers)
+	c.mutex.Unlock()
+	return c.driverClient.DeregisterContext(ctx)
+}
+
+func (c *rdClient) DeregisterNode(id string) error {
+	return c.DeregisterNodeContext(context.Background(), id)
+}
+
+// DeregisterNodeContext 注销单个节点，节点来自配置时同时不再记录，下次Reload会按配置重新注册
+func (c *rdClient) DeregisterNodeContext(ctx context.Context, id string) error {
+	c.mutex.Lock()
+	delete(c.registers, id)
+	c.mutex.Unlock()
+	return c.driverClient.DeregisterNodeContext(ctx, id)
+}
+
+func (c *rdClient) Reload(cfg *config.Config) error {
+	return c.ReloadContext(context.Background(), cfg)
+}
+
+// ReloadContext 按新配置增量更新：注册新增的节点、注销移除的节点、重新注册有变化的节点，
+// 监听新启用的服务、停止已移除或禁用的服务、按新配置重新监听有变化的服务，其余服务已发现的节点保持不变。
+// driver、endpoints、tls、region、zone等连接相关的配置不能在运行时修改。
+// 配置校验失败时不做任何修改，部分服务生效失败时返回全部错误，已生效的部分保留
+func (c *rdClient) ReloadContext(ctx context.Context, cfg *config.Config) error {
+	if err := config.Validate(cfg); err != nil {
+		return err
+	}
+	cfg = config.WithDefaults(cfg)
+	c.statusMutex.Lock() //与摘流、维护、就绪检查互斥，重新注册时保留节点状态
+	defer c.statusMutex.Unlock()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !reflect.DeepEqual(c.conn, connection(cfg)) {
+		return errors.New("reload: connection settings (driver, endpoints, scheme, timeout, token, datacenter, partition, tls, region, zone) cannot be changed at runtime")
+	}
+	return c.apply(ctx, cfg)
+}
+
+// keepStatus 调用方传入的节点没有运行状态，重新注册或更新时沿用已注册节点的状态(摘流、维护、不健康)及其原因
+func keepStatus(rs, cur *config.RegisterNode) *config.RegisterNode {
+	n := *rs
+	n.Status = cur.Status
+	n.Metadata = make(map[string]string, len(rs.Metadata)+2)
+	for k, v := range rs.Metadata {
+		n.Metadata[k] = v
+	}
+	for _, k := range []string{config.MetaMaintenanceReason, config.MetaUnhealthyReason} {
+		if v, ok := cur.Metadata[k]; ok {
+			n.Metadata[k] = v
+		}
+	}
+	return &n
+}
+
+// apply 使已注册和已监听的服务与cfg一致，cfg需已校验并填充默认值
+func (c *rdClient) apply(ctx context.Context, cfg *config.Config) error {
+	var errs []error
+	registers := make(map[string]bool, len(cfg.Registers))
+	for _, rs := range cfg.Registers {
+		registers[rs.Id] = true
+	}
+	for id := range c.registers {
+		if !registers[id] {
+			if err := c.driverClient.DeregisterNodeContext(ctx, id); err != nil {
+				errs = append(errs, err)
+			}
+			delete(c.registers, id)
+		}
+	}
+	for _, rs := range cfg.Registers {
+		if old, ok := c.registers[rs.Id]; ok && reflect.DeepEqual(old, rs) {
+			continue
+		}
+		if err := c.register(ctx, rs); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.registers[rs.Id] = rs
+	}
+
+	discoveries := make(map[string]bool, len(cfg.Discoveries))
+	for _, ds := range cfg.Discoveries {
+		if ds.Enable {
+			discoveries[ds.Name] = true
+		}
+	}
+	for name := range c.discoveries {
+		if !discoveries[name] {
+			if err := c.Unwatch(name); err != nil {
+				errs = append(errs, err)
+			}
+			delete(c.discoveries, name)
+		}
+	}
+	for _, ds := range cfg.Discoveries {
+		if !ds.Enable {
+			continue
+		}
+		if old, ok := c.discoveries[ds.Name]; ok && reflect.DeepEqual(old, ds) {
+			continue
+		}
+		if err := c.WatchContext(ctx, ds); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.discoveries[ds.Name] = ds
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,75 @@
+package rd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/baowk/dilu-rd/config"
+)
+
+func TestReloadKeepsStatus(t *testing.T) {
+	srv := fakeAgent()
+	defer srv.Close()
+	ctx := context.Background()
+	cfg := &config.Config{Driver: "consul", Endpoints: []string{strings.TrimPrefix(srv.URL, "http://")}, Scheme: "http",
+		Registers: []*config.RegisterNode{{Name: "api", Addr: "127.0.0.1", Port: 8080, Protocol: "http", Weight: 10}}}
+	c, err := NewRDClientV2(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx)
+	if err := c.SetMaintenance(ctx, "127.0.0.1:8080", true, "debug"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Registers[0].Weight = 20
+	if err := c.Reload(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	n := c.RegisteredNodes()[0]
+	if n.Weight != 20 {
+		t.Errorf("weight = %d, want 20", n.Weight)
+	}
+	if n.Status != config.StatusMaintenance || n.Metadata[config.MetaMaintenanceReason] != "debug" {
+		t.Errorf("status = %q reason = %q, want maintenance kept across reload", n.Status, n.Metadata[config.MetaMaintenanceReason])
+	}
+}
+
+func TestUpdateKeepsStatus(t *testing.T) {
+	srv := fakeAgent()
+	defer srv.Close()
+	ctx := context.Background()
+	c, err := NewRDClientV2(ctx, &config.Config{Driver: "consul", Endpoints: []string{strings.TrimPrefix(srv.URL, "http://")}, Scheme: "http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx)
+	s := &config.RegisterNode{Name: "api", Addr: "127.0.0.1", Port: 8080, Protocol: "http", Weight: 10}
+	if err := c.Register(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetMaintenance(ctx, "127.0.0.1:8080", true, "debug"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Weight = 20 //运行时调整权重和标签
+	s.Tags = []string{"canary"}
+	if err := c.Update(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	n := c.RegisteredNodes()[0]
+	if n.Weight != 20 || len(n.Tags) != 1 {
+		t.Errorf("weight = %d tags = %v, want update applied", n.Weight, n.Tags)
+	}
+	if n.Status != config.StatusMaintenance || n.Metadata[config.MetaMaintenanceReason] != "debug" {
+		t.Errorf("status = %q reason = %q, want maintenance kept across update", n.Status, n.Metadata[config.MetaMaintenanceReason])
+	}
+
+	if err := c.SetMaintenance(ctx, "127.0.0.1:8080", false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.RegisteredNodes()[0]; n.Status != "" || n.Weight != 20 {
+		t.Errorf("status = %q weight = %d after leaving maintenance", n.Status, n.Weight)
+	}
+}
@@ -1,6 +1,7 @@
 package rd
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -16,62 +17,113 @@ import (
 type RDClient interface {
 	Register(s *config.RegisterNode) error
 	Deregister()
+	DeregisterNode(id string) error
+	Update(s *config.RegisterNode) error
+	Drain(id string, d time.Duration) error
+	SetHealthServer(h HealthServer)
+	AddCheck(name string, fn CheckFunc) error
+	RemoveCheck(name string)
+	CheckResults() []CheckResult
+	SetHealthFunc(fn consul.HealthFunc) error
+	ActiveEndpoint() string
+	SetMaintenance(id string, enable bool, reason string) error
+	SetMaintenanceContext(ctx context.Context, id string, enable bool, reason string) error
+	RegisteredNodes() []*config.RegisterNode
 	Watch(s *config.DiscoveryNode) error
 	GetService(name string, clientIp string) (*models.ServiceNode, error)
+	GetServiceWithContext(ctx context.Context, name string, clientIp string) (*models.ServiceNode, error)
+	GetServiceWithSelector(name string, clientIp string, selector string) (*models.ServiceNode, error)
+	SetTrafficSplit(name string, split []*config.TrafficSubset) error
+	Reload(cfg *config.Config) error
+	Close(ctx context.Context) error
 }
 
-func NewRDClient(cfg *config.Config) (client RDClient, err error) {
+// driverClient 驱动实现的全部方法，RDClient和RDClientV2都基于它
+type driverClient interface {
+	Register(s *config.RegisterNode) error
+	Deregister()
+	DeregisterNode(id string) error
+	Update(s *config.RegisterNode) error
+	Watch(s *config.DiscoveryNode) error
+	GetService(name string, clientIp string) (*models.ServiceNode, error)
+	GetServiceWithContext(ctx context.Context, name string, clientIp string) (*models.ServiceNode, error)
+	GetServiceWithSelector(name string, clientIp string, selector string) (*models.ServiceNode, error)
+	SetTrafficSplit(name string, split []*config.TrafficSubset) error
+	Close(ctx context.Context) error
+	RegisterContext(ctx context.Context, s *config.RegisterNode) error
+	DeregisterContext(ctx context.Context) error
+	DeregisterNodeContext(ctx context.Context, id string) error
+	UpdateContext(ctx context.Context, s *config.RegisterNode) error
+	RegisteredNode(id string) (*config.RegisterNode, bool)
+	RegisteredNodes() []*config.RegisterNode
+	SetMaintenance(id string, enable bool, reason string) error
+	SetMaintenanceContext(ctx context.Context, id string, enable bool, reason string) error
+	WatchContext(ctx context.Context, s *config.DiscoveryNode) error
+	Unwatch(name string) error
+	GetServiceWithSelectorContext(ctx context.Context, name string, clientIp string, selector string) (*models.ServiceNode, error)
+	SetLocality(region, zone string)
+	Go(fn func(ctx context.Context)) error
+}
+
+func NewRDClient(cfg *config.Config) (RDClient, error) {
+	client, err := newClient(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// newClient 校验配置后创建驱动并注册、监听配置中的服务，ctx作用于首次注册和首次发现，出错时关闭已创建的客户端。
+// 默认值填充在配置副本上，不修改cfg
+func newClient(ctx context.Context, cfg *config.Config) (*rdClient, error) {
+	if err := config.Validate(cfg); err != nil {
+		return nil, err
+	}
+	cfg = config.WithDefaults(cfg)
+	var dc driverClient
+	var err error
 	if cfg.Driver == "etcd" {
 		c := clientv3.Config{
 			Endpoints:   cfg.Endpoints,
 			DialTimeout: cfg.Timeout,
 		}
-		client, err = etcd.NewClient(&c)
+		dc, err = etcd.NewClient(&c)
 	} else if cfg.Driver == "consul" {
-		c := api.Config{
-			Address:  cfg.Endpoints[0],
-			Scheme:   cfg.Scheme,
-			WaitTime: cfg.Timeout,
-		}
-		client, err = consul.NewClient(&c)
+		dc, err = consul.NewClient(consulConfig(cfg), cfg.Endpoints)
+	} else {
+		err = fmt.Errorf("unsupported driver: %s", cfg.Driver)
 	}
 	if err != nil {
-		return
+		return nil, err
 	}
-	for _, rs := range cfg.Registers {
-		if rs.Addr == "" || rs.Port <= 0 || rs.Port > 65535 {
-			panic("register node addr or port is error")
-		}
-		if rs.Protocol != "http" && rs.Protocol != "grpc" {
-			panic("register node protocol is error")
-		}
-		if rs.Name == "" {
-			panic("register node name is error")
-		}
-		if rs.Id == "" {
-			rs.Id = fmt.Sprintf("%s:%d", rs.Addr, rs.Port)
-		}
-		if rs.FailLimit <= 0 {
-			rs.FailLimit = 3
-		}
-		if rs.Interval <= 0 {
-			rs.Interval = time.Duration(5 * time.Second)
-		}
-		if rs.Timeout <= 0 {
-			rs.Timeout = time.Duration(10 * time.Second)
-		}
-		err = client.Register(rs)
-		if err != nil {
-			return
-		}
+	dc.SetLocality(cfg.Region, cfg.Zone)
+	client := newRDClient(dc, cfg)
+	if err := client.apply(ctx, cfg); err != nil {
+		client.Close(ctx)
+		return nil, err
+	}
+	return client, nil
+}
+
+func consulConfig(cfg *config.Config) *api.Config {
+	c := api.Config{
+		Scheme:     cfg.Scheme,
+		WaitTime:   cfg.Timeout,
+		Token:      cfg.Token,
+		Datacenter: cfg.Datacenter,
+		Partition:  cfg.Partition,
 	}
-	for _, ds := range cfg.Discoveries {
-		if ds.Enable {
-			err = client.Watch(ds)
-			if err != nil {
-				return
-			}
+	if cfg.TLS != nil {
+		if c.Scheme == "" {
+			c.Scheme = "https"
+		}
+		c.TLSConfig = api.TLSConfig{
+			Address:            cfg.TLS.ServerName,
+			CAFile:             cfg.TLS.CAFile,
+			CertFile:           cfg.TLS.CertFile,
+			KeyFile:            cfg.TLS.KeyFile,
+			InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
 		}
 	}
-	return
+	return &c
 }
@@ -0,0 +1,129 @@
+package rd
+
+import (
+	"context"
+	"time"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/driver/consul"
+	"github.com/baowk/dilu-rd/models"
+)
+
+// RDClientV2 所有操作都带ctx的注册发现客户端。
+// Register、Watch 的ctx只作用于首次注册和首次发现，后台的续约和监听在Close时停止；
+// Close 会停止所有后台协程、注销已注册的服务、关闭已发现节点的grpc连接，并等待协程全部退出
+type RDClientV2 interface {
+	Register(ctx context.Context, s *config.RegisterNode) error
+	Deregister(ctx context.Context) error
+	DeregisterNode(ctx context.Context, id string) error
+	Update(ctx context.Context, s *config.RegisterNode) error
+	Drain(ctx context.Context, id string, d time.Duration) error
+	SetHealthServer(h HealthServer)
+	AddCheck(name string, fn CheckFunc) error
+	RemoveCheck(name string)
+	CheckResults() []CheckResult
+	SetHealthFunc(fn consul.HealthFunc) error
+	ActiveEndpoint() string
+	SetMaintenance(ctx context.Context, id string, enable bool, reason string) error
+	SetMaintenanceContext(ctx context.Context, id string, enable bool, reason string) error
+	RegisteredNodes() []*config.RegisterNode
+	Watch(ctx context.Context, s *config.DiscoveryNode) error
+	GetService(ctx context.Context, name string, clientIp string) (*models.ServiceNode, error)
+	GetServiceWithSelector(ctx context.Context, name string, clientIp string, selector string) (*models.ServiceNode, error)
+	SetTrafficSplit(name string, split []*config.TrafficSubset) error
+	Reload(ctx context.Context, cfg *config.Config) error
+	Close(ctx context.Context) error
+}
+
+func NewRDClientV2(ctx context.Context, cfg *config.Config) (RDClientV2, error) {
+	client, err := newClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &rdClientV2{client: client}, nil
+}
+
+type rdClientV2 struct {
+	client *rdClient
+}
+
+func (c *rdClientV2) Register(ctx context.Context, s *config.RegisterNode) error {
+	return c.client.RegisterContext(ctx, s)
+}
+
+func (c *rdClientV2) Deregister(ctx context.Context) error {
+	return c.client.DeregisterContext(ctx)
+}
+
+func (c *rdClientV2) DeregisterNode(ctx context.Context, id string) error {
+	return c.client.DeregisterNodeContext(ctx, id)
+}
+
+func (c *rdClientV2) Update(ctx context.Context, s *config.RegisterNode) error {
+	return c.client.UpdateContext(ctx, s)
+}
+
+func (c *rdClientV2) Drain(ctx context.Context, id string, d time.Duration) error {
+	return c.client.DrainContext(ctx, id, d)
+}
+
+func (c *rdClientV2) SetHealthServer(h HealthServer) {
+	c.client.SetHealthServer(h)
+}
+
+func (c *rdClientV2) AddCheck(name string, fn CheckFunc) error {
+	return c.client.AddCheck(name, fn)
+}
+
+func (c *rdClientV2) RemoveCheck(name string) {
+	c.client.RemoveCheck(name)
+}
+
+func (c *rdClientV2) CheckResults() []CheckResult {
+	return c.client.CheckResults()
+}
+
+func (c *rdClientV2) SetHealthFunc(fn consul.HealthFunc) error {
+	return c.client.SetHealthFunc(fn)
+}
+
+func (c *rdClientV2) ActiveEndpoint() string {
+	return c.client.ActiveEndpoint()
+}
+
+func (c *rdClientV2) SetMaintenance(ctx context.Context, id string, enable bool, reason string) error {
+	return c.client.SetMaintenanceContext(ctx, id, enable, reason)
+}
+
+// SetMaintenanceContext 同SetMaintenance，使RDClientV2满足AdminHandler的Maintainer
+func (c *rdClientV2) SetMaintenanceContext(ctx context.Context, id string, enable bool, reason string) error {
+	return c.SetMaintenance(ctx, id, enable, reason)
+}
+
+func (c *rdClientV2) RegisteredNodes() []*config.RegisterNode {
+	return c.client.RegisteredNodes()
+}
+
+func (c *rdClientV2) Watch(ctx context.Context, s *config.DiscoveryNode) error {
+	return c.client.WatchContext(ctx, s)
+}
+
+func (c *rdClientV2) GetService(ctx context.Context, name string, clientIp string) (*models.ServiceNode, error) {
+	return c.client.GetServiceWithContext(ctx, name, clientIp)
+}
+
+func (c *rdClientV2) GetServiceWithSelector(ctx context.Context, name string, clientIp string, selector string) (*models.ServiceNode, error) {
+	return c.client.GetServiceWithSelectorContext(ctx, name, clientIp, selector)
+}
+
+func (c *rdClientV2) SetTrafficSplit(name string, split []*config.TrafficSubset) error {
+	return c.client.SetTrafficSplit(name, split)
+}
+
+func (c *rdClientV2) Reload(ctx context.Context, cfg *config.Config) error {
+	return c.client.ReloadContext(ctx, cfg)
+}
+
+func (c *rdClientV2) Close(ctx context.Context) error {
+	return c.client.Close(ctx)
+}
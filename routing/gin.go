@@ -0,0 +1,15 @@
+package routing
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// GinMiddleware 从请求头中提取路由提示放入请求ctx，之后可通过 c.Request.Context() 传给 GetServiceWithContext
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if hints := FromHeader(c.Request.Header); len(hints) > 0 {
+			c.Request = c.Request.WithContext(WithHints(c.Request.Context(), hints))
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,82 @@
+package routing
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// FromIncoming 从grpc请求的metadata中提取路由提示
+func FromIncoming(ctx context.Context) Hints {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	var hints Hints
+	for k, vs := range md {
+		if len(vs) == 0 || !strings.HasPrefix(k, HeaderPrefix) || len(k) == len(HeaderPrefix) {
+			continue
+		}
+		if hints == nil {
+			hints = make(Hints)
+		}
+		hints[k[len(HeaderPrefix):]] = vs[0]
+	}
+	return hints
+}
+
+// appendOutgoing 把路由提示写入grpc请求的metadata
+func appendOutgoing(ctx context.Context) context.Context {
+	hints := FromContext(ctx)
+	if len(hints) == 0 {
+		return ctx
+	}
+	kv := make([]string, 0, len(hints)*2)
+	for k, v := range hints {
+		kv = append(kv, HeaderPrefix+k, v)
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// UnaryServerInterceptor 从metadata中提取路由提示放入请求ctx
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(WithHints(ctx, FromIncoming(ctx)), req)
+	}
+}
+
+// StreamServerInterceptor 从metadata中提取路由提示放入流的ctx
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		if hints := FromIncoming(ctx); len(hints) > 0 {
+			ss = &hintsServerStream{ServerStream: ss, ctx: WithHints(ctx, hints)}
+		}
+		return handler(srv, ss)
+	}
+}
+
+type hintsServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *hintsServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// UnaryClientInterceptor 把ctx中的路由提示传给下游
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(appendOutgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor 把ctx中的路由提示传给下游
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(appendOutgoing(ctx), desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,62 @@
+package routing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// HeaderPrefix 携带路由提示的请求头(grpc metadata)前缀，如 x-dilu-version: v2 表示优先路由到 version=v2 的节点
+const HeaderPrefix = "x-dilu-"
+
+// Hints 请求级的路由提示，键值与节点元数据(或标签)匹配
+type Hints map[string]string
+
+type hintsKey struct{}
+
+// WithHints 把路由提示放入ctx，与ctx中已有的提示合并，同名键以新值为准
+func WithHints(ctx context.Context, hints Hints) context.Context {
+	if len(hints) == 0 {
+		return ctx
+	}
+	merged := make(Hints, len(hints))
+	for k, v := range FromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range hints {
+		merged[strings.ToLower(k)] = v
+	}
+	return context.WithValue(ctx, hintsKey{}, merged)
+}
+
+// FromContext 获取ctx中的路由提示，没有时返回nil
+func FromContext(ctx context.Context) Hints {
+	if ctx == nil {
+		return nil
+	}
+	hints, _ := ctx.Value(hintsKey{}).(Hints)
+	return hints
+}
+
+// FromHeader 从请求头中提取路由提示
+func FromHeader(h http.Header) Hints {
+	var hints Hints
+	for k, vs := range h {
+		key := strings.ToLower(k)
+		if len(vs) == 0 || !strings.HasPrefix(key, HeaderPrefix) || len(key) == len(HeaderPrefix) {
+			continue
+		}
+		if hints == nil {
+			hints = make(Hints)
+		}
+		hints[key[len(HeaderPrefix):]] = vs[0]
+	}
+	return hints
+}
+
+// InjectHeader 把ctx中的路由提示写入请求头，用于向下游传递
+func InjectHeader(ctx context.Context, h http.Header) {
+	for k, v := range FromContext(ctx) {
+		h.Set(HeaderPrefix+k, v)
+	}
+}
@@ -0,0 +1,26 @@
+package routing
+
+import (
+	"net/http"
+)
+
+// Transport 发起http请求时把请求ctx中的路由提示写入请求头
+type Transport struct {
+	Base http.RoundTripper //为空时使用http.DefaultTransport
+}
+
+func NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{Base: base}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if hints := FromContext(req.Context()); len(hints) > 0 {
+		req = req.Clone(req.Context())
+		InjectHeader(req.Context(), req.Header)
+	}
+	return base.RoundTrip(req)
+}
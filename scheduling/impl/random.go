@@ -2,13 +2,15 @@ package impl
 
 import (
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/baowk/dilu-rd/models"
 )
 
 type RandomHandler struct {
-	r *rand.Rand
+	mutex sync.Mutex //rand.Rand不能并发使用
+	r     *rand.Rand
 }
 
 func NewRandomHandler() *RandomHandler {
@@ -22,9 +24,11 @@ func (rh *RandomHandler) GetServiceNode(nodes []*models.ServiceNode, name string
 		return nil
 	}
 
+	rh.mutex.Lock()
+	defer rh.mutex.Unlock()
 	for i := 0; i < len(nodes); i++ {
 		idx := rh.r.Intn(len(nodes))
-		if nodes[idx].Enable() {
+		if nodes[idx].Available() {
 			return nodes[idx]
 		}
 	}
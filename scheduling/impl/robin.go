@@ -1,11 +1,14 @@
 package impl
 
 import (
+	"sync"
+
 	"github.com/baowk/dilu-rd/models"
 )
 
 type RoundRobinHandler struct {
-	cur map[string]int
+	mutex sync.Mutex //调度可能在多个协程中同时进行
+	cur   map[string]int
 }
 
 func NewRoundRobinHandler() *RoundRobinHandler {
@@ -18,18 +21,14 @@ func (r *RoundRobinHandler) GetServiceNode(nodes []*models.ServiceNode, name str
 	if len(nodes) == 0 {
 		return nil
 	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	start := r.cur[name]
 	for i := 0; i < len(nodes); i++ {
-		if idx, ok := r.cur[name]; ok {
-			useIdx := idx % len(nodes)
-			r.cur[name] = useIdx + 1
-			if nodes[useIdx].Enable() {
-				return nodes[useIdx]
-			}
-		} else {
-			r.cur[name] = 0
-			if nodes[0].Enable() {
-				return nodes[0]
-			}
+		idx := (start + i) % len(nodes)
+		if nodes[idx].Available() {
+			r.cur[name] = idx + 1
+			return nodes[idx]
 		}
 	}
 	return nil
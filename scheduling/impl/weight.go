@@ -0,0 +1,53 @@
+package impl
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/baowk/dilu-rd/models"
+)
+
+type WeightedRandomHandler struct {
+	mutex sync.Mutex //rand.Rand不能并发使用
+	r     *rand.Rand
+}
+
+func NewWeightedRandomHandler() *WeightedRandomHandler {
+	return &WeightedRandomHandler{
+		r: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// GetServiceNode 按节点当前权重随机选择，所有可用节点权重都为0时退化为普通随机
+func (wh *WeightedRandomHandler) GetServiceNode(nodes []*models.ServiceNode, name string) *models.ServiceNode {
+	total := 0
+	enabled := make([]*models.ServiceNode, 0, len(nodes))
+	for _, n := range nodes {
+		if n.Available() {
+			enabled = append(enabled, n)
+			if n.Weight > 0 {
+				total += n.Weight
+			}
+		}
+	}
+	if len(enabled) == 0 {
+		return nil
+	}
+	wh.mutex.Lock()
+	defer wh.mutex.Unlock()
+	if total == 0 {
+		return enabled[wh.r.Intn(len(enabled))]
+	}
+	w := wh.r.Intn(total)
+	for _, n := range enabled {
+		if n.Weight <= 0 {
+			continue
+		}
+		if w < n.Weight {
+			return n
+		}
+		w -= n.Weight
+	}
+	return nil
+}
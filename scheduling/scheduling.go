@@ -1,8 +1,10 @@
 package scheduling
 
 import (
+	"github.com/baowk/dilu-rd/config"
 	"github.com/baowk/dilu-rd/models"
 	"github.com/baowk/dilu-rd/scheduling/impl"
+	"github.com/baowk/dilu-rd/selector"
 )
 
 type SchedulingHandler interface {
@@ -16,8 +18,8 @@ func GetHandler(algorithm string) SchedulingHandler {
 		return impl.NewRoundRobinHandler()
 	case AlgorithmRandom:
 		return impl.NewRandomHandler()
-	// case AlgorithmWeightedRandom:
-	// 	return NewWeightedRandomHandler()
+	case AlgorithmWeightedRandom:
+		return impl.NewWeightedRandomHandler()
 	// case AlgorithmIpHash:
 	// 	return NewIpHashHandler()
 	default:
@@ -25,12 +27,34 @@ func GetHandler(algorithm string) SchedulingHandler {
 	}
 }
 
+// NewHandler 根据发现配置创建调度器，region、zone为本服务所在位置。
+// 节点先按流量比例选出子集，再经过标签和选择表达式过滤，然后做就近路由，最后按调度算法选择。
+// 返回的调度器实现了TrafficSplitter，可在运行时调整流量比例
+func NewHandler(s *config.DiscoveryNode, region, zone string) (SchedulingHandler, error) {
+	sh := GetHandler(s.SchedulingAlgorithm)
+	if s.ZoneAware {
+		sh = NewZoneAwareHandler(sh, region, zone, s.ZoneThreshold)
+	}
+	sel, err := selector.Parse(s.Selector)
+	if err != nil {
+		return nil, err
+	}
+	if sel = append(selector.Tag(s.Tag), sel...); len(sel) > 0 {
+		sh = NewSelectorHandler(sh, sel)
+	}
+	ts, err := NewTrafficSplitHandler(sh, s.TrafficSplit)
+	if err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
 type Algorithm string
 
 const (
-	AlgorithmRandom     Algorithm = "random"
-	AlgorithmRoundRobin Algorithm = "robin"
-	// AlgorithmWeightedRandom Algorithm = "weight"
+	AlgorithmRandom         Algorithm = "random"
+	AlgorithmRoundRobin     Algorithm = "robin"
+	AlgorithmWeightedRandom Algorithm = "weight"
 	// AlgorithmIpHash         Algorithm = "iphash"
 )
 
@@ -40,8 +64,8 @@ func GetAlgorithm(name string) Algorithm {
 		return AlgorithmRandom
 	case "robin":
 		return AlgorithmRoundRobin
-	// case "weight":
-	// 	return AlgorithmWeightedRandom
+	case "weight":
+		return AlgorithmWeightedRandom
 	// case "iphash":
 	// 	return AlgorithmIpHash
 	default:
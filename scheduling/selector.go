@@ -0,0 +1,23 @@
+package scheduling
+
+import (
+	"github.com/baowk/dilu-rd/models"
+	"github.com/baowk/dilu-rd/selector"
+)
+
+// SelectorHandler 先按选择表达式过滤节点，再由next选择
+type SelectorHandler struct {
+	next SchedulingHandler
+	sel  selector.Selector
+}
+
+func NewSelectorHandler(next SchedulingHandler, sel selector.Selector) *SelectorHandler {
+	return &SelectorHandler{
+		next: next,
+		sel:  sel,
+	}
+}
+
+func (h *SelectorHandler) GetServiceNode(nodes []*models.ServiceNode, name string) *models.ServiceNode {
+	return h.next.GetServiceNode(selector.Filter(h.sel, nodes), name)
+}
@@ -0,0 +1,106 @@
+package scheduling
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/models"
+	"github.com/baowk/dilu-rd/selector"
+)
+
+// TrafficSplitter 支持运行时调整流量比例的调度器
+type TrafficSplitter interface {
+	SetTrafficSplit(split []*config.TrafficSubset) error
+}
+
+type trafficSubset struct {
+	name    string
+	sel     selector.Selector
+	percent int
+}
+
+// TrafficSplitHandler 按百分比把流量分配到不同的节点子集(如按版本灰度)，再由next在子集内选择。
+// 剩余的百分比分配给不属于任何子集的节点，选中的子集没有可用节点时退回到全部节点
+type TrafficSplitHandler struct {
+	next    SchedulingHandler
+	rwmutex sync.RWMutex
+	subsets []trafficSubset
+}
+
+func NewTrafficSplitHandler(next SchedulingHandler, split []*config.TrafficSubset) (*TrafficSplitHandler, error) {
+	h := &TrafficSplitHandler{next: next}
+	if err := h.SetTrafficSplit(split); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// SetTrafficSplit 替换流量分配规则，可在运行时调用，规则有误时返回错误并保留原规则
+func (h *TrafficSplitHandler) SetTrafficSplit(split []*config.TrafficSubset) error {
+	subsets := make([]trafficSubset, 0, len(split))
+	total := 0
+	for i, ts := range split {
+		if ts == nil {
+			return fmt.Errorf("traffic split %d: is nil", i)
+		}
+		if ts.Percent < 0 || ts.Percent > 100 {
+			return fmt.Errorf("traffic split %d: percent %d out of range", i, ts.Percent)
+		}
+		sel, err := selector.Parse(ts.Selector)
+		if err != nil {
+			return err
+		}
+		name := ts.Name
+		if name == "" {
+			name = ts.Selector
+		}
+		total += ts.Percent
+		subsets = append(subsets, trafficSubset{name: name, sel: sel, percent: ts.Percent})
+	}
+	if total > 100 {
+		return errors.New("traffic split percent sum exceeds 100")
+	}
+	h.rwmutex.Lock()
+	h.subsets = subsets
+	h.rwmutex.Unlock()
+	return nil
+}
+
+func (h *TrafficSplitHandler) GetServiceNode(nodes []*models.ServiceNode, name string) *models.ServiceNode {
+	h.rwmutex.RLock()
+	subsets := h.subsets
+	h.rwmutex.RUnlock()
+	if len(subsets) == 0 {
+		return h.next.GetServiceNode(nodes, name)
+	}
+	p := rand.Intn(100)
+	for _, ts := range subsets {
+		if p < ts.percent {
+			if n := h.next.GetServiceNode(selector.Filter(ts.sel, nodes), name+"#"+ts.name); n != nil {
+				return n
+			}
+			return h.next.GetServiceNode(nodes, name)
+		}
+		p -= ts.percent
+	}
+	rest := make([]*models.ServiceNode, 0, len(nodes))
+	for _, n := range nodes {
+		matched := false
+		for _, ts := range subsets {
+			if ts.sel.Match(n) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			rest = append(rest, n)
+		}
+	}
+	if n := h.next.GetServiceNode(rest, name); n != nil {
+		return n
+	}
+	return h.next.GetServiceNode(nodes, name)
+}
@@ -0,0 +1,125 @@
+package scheduling
+
+import (
+	"math"
+	"testing"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/models"
+	"github.com/baowk/dilu-rd/scheduling/impl"
+)
+
+func newNode(id string, metadata map[string]string) *models.ServiceNode {
+	n := &models.ServiceNode{RegisterNode: config.RegisterNode{Id: id, Metadata: metadata}}
+	n.SetEnable(true)
+	return n
+}
+
+// share 选择count次，返回按metadata[key]统计的比例，没有该键的节点计入""
+func share(h SchedulingHandler, nodes []*models.ServiceNode, key string, count int) map[string]float64 {
+	rs := make(map[string]float64)
+	for i := 0; i < count; i++ {
+		n := h.GetServiceNode(nodes, "svc")
+		if n == nil {
+			rs["nil"]++
+			continue
+		}
+		rs[n.Metadata[key]]++
+	}
+	for k := range rs {
+		rs[k] /= float64(count)
+	}
+	return rs
+}
+
+func versioned() []*models.ServiceNode {
+	return []*models.ServiceNode{
+		newNode("a", map[string]string{"version": "v1"}),
+		newNode("b", map[string]string{"version": "v1"}),
+		newNode("c", map[string]string{"version": "v2"}),
+		newNode("d", map[string]string{"version": "v2"}),
+		newNode("e", nil),
+		newNode("f", nil),
+	}
+}
+
+func assertShare(t *testing.T, got map[string]float64, want map[string]float64) {
+	t.Helper()
+	for k, w := range want {
+		if math.Abs(got[k]-w) > 0.05 {
+			t.Errorf("share of %q = %.3f, want about %.2f (all %v)", k, got[k], w, got)
+		}
+	}
+	for k := range got {
+		if _, ok := want[k]; !ok {
+			t.Errorf("unexpected share of %q = %.3f", k, got[k])
+		}
+	}
+}
+
+func TestTrafficSplit(t *testing.T) {
+	nodes := versioned()
+	h, err := NewTrafficSplitHandler(impl.NewRoundRobinHandler(), []*config.TrafficSubset{
+		{Name: "canary", Selector: "version=v2", Percent: 20},
+		{Selector: "version=v1", Percent: 30},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//剩余的50%分配给不属于任何子集的节点
+	assertShare(t, share(h, nodes, "version", 10000), map[string]float64{"v2": 0.2, "v1": 0.3, "": 0.5})
+
+	//子集的节点都不可用时退回到全部节点
+	nodes[2].SetEnable(false)
+	nodes[3].SetEnable(false)
+	assertShare(t, share(h, nodes, "version", 10000), map[string]float64{"v1": 0.3 + 0.2/2, "": 0.5 + 0.2/2})
+}
+
+func TestTrafficSplitFallback(t *testing.T) {
+	nodes := versioned()
+	h, err := NewTrafficSplitHandler(impl.NewRoundRobinHandler(), []*config.TrafficSubset{{Selector: "version=v3", Percent: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//没有匹配的节点时退回到全部节点
+	assertShare(t, share(h, nodes, "version", 6000), map[string]float64{"v1": 1.0 / 3, "v2": 1.0 / 3, "": 1.0 / 3})
+
+	//全部节点都属于子集时，剩余的流量也退回到全部节点
+	h, err = NewTrafficSplitHandler(impl.NewRoundRobinHandler(), []*config.TrafficSubset{{Selector: "version", Percent: 50}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertShare(t, share(h, nodes[:4], "version", 6000), map[string]float64{"v1": 0.5, "v2": 0.5})
+}
+
+func TestSetTrafficSplit(t *testing.T) {
+	nodes := versioned()
+	h, err := NewTrafficSplitHandler(impl.NewRoundRobinHandler(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertShare(t, share(h, nodes, "version", 6000), map[string]float64{"v1": 1.0 / 3, "v2": 1.0 / 3, "": 1.0 / 3})
+
+	if err := h.SetTrafficSplit([]*config.TrafficSubset{{Selector: "version=v2", Percent: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	assertShare(t, share(h, nodes, "version", 1000), map[string]float64{"v2": 1})
+
+	for _, split := range [][]*config.TrafficSubset{
+		{nil},
+		{{Selector: "version=v1", Percent: 101}},
+		{{Selector: "version=v1", Percent: 60}, {Selector: "version=v2", Percent: 50}},
+		{{Selector: "!version=v1", Percent: 10}},
+	} {
+		if err := h.SetTrafficSplit(split); err == nil {
+			t.Errorf("SetTrafficSplit(%v) should fail", split)
+		}
+	}
+	//规则有误时保留原规则
+	assertShare(t, share(h, nodes, "version", 1000), map[string]float64{"v2": 1})
+
+	if err := h.SetTrafficSplit(nil); err != nil {
+		t.Fatal(err)
+	}
+	assertShare(t, share(h, nodes, "version", 6000), map[string]float64{"v1": 1.0 / 3, "v2": 1.0 / 3, "": 1.0 / 3})
+}
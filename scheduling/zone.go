@@ -0,0 +1,66 @@
+package scheduling
+
+import (
+	"github.com/baowk/dilu-rd/models"
+)
+
+// ZoneAwareHandler 就近路由：依次在同可用区、同地域、全部节点中选择，
+// 当前范围内可用节点比例不低于阈值时才使用该范围，否则溢出到更大的范围，最终由next选择节点
+type ZoneAwareHandler struct {
+	next      SchedulingHandler
+	region    string
+	zone      string
+	threshold float64
+}
+
+func NewZoneAwareHandler(next SchedulingHandler, region, zone string, threshold float64) *ZoneAwareHandler {
+	return &ZoneAwareHandler{
+		next:      next,
+		region:    region,
+		zone:      zone,
+		threshold: threshold,
+	}
+}
+
+func (z *ZoneAwareHandler) GetServiceNode(nodes []*models.ServiceNode, name string) *models.ServiceNode {
+	if z.zone != "" {
+		if local := z.filter(nodes, true); z.sufficient(local) {
+			return z.next.GetServiceNode(local, name)
+		}
+	}
+	if z.region != "" {
+		if local := z.filter(nodes, false); z.sufficient(local) {
+			return z.next.GetServiceNode(local, name)
+		}
+	}
+	return z.next.GetServiceNode(nodes, name)
+}
+
+// filter 同可用区(sameZone)或同地域的节点，客户端未配置地域时只比较可用区
+func (z *ZoneAwareHandler) filter(nodes []*models.ServiceNode, sameZone bool) []*models.ServiceNode {
+	rs := make([]*models.ServiceNode, 0, len(nodes))
+	for _, n := range nodes {
+		if z.region != "" && n.Region != z.region {
+			continue
+		}
+		if sameZone && n.Zone != z.zone {
+			continue
+		}
+		rs = append(rs, n)
+	}
+	return rs
+}
+
+// sufficient 至少有一个可用节点且可用比例不低于阈值
+func (z *ZoneAwareHandler) sufficient(nodes []*models.ServiceNode) bool {
+	if len(nodes) == 0 {
+		return false
+	}
+	healthy := 0
+	for _, n := range nodes {
+		if n.Available() {
+			healthy++
+		}
+	}
+	return healthy > 0 && float64(healthy)/float64(len(nodes)) >= z.threshold
+}
@@ -0,0 +1,74 @@
+package scheduling
+
+import (
+	"testing"
+
+	"github.com/baowk/dilu-rd/config"
+	"github.com/baowk/dilu-rd/models"
+	"github.com/baowk/dilu-rd/scheduling/impl"
+)
+
+func zoned(id, region, zone string) *models.ServiceNode {
+	n := &models.ServiceNode{RegisterNode: config.RegisterNode{Id: id, Region: region, Zone: zone}}
+	n.SetEnable(true)
+	return n
+}
+
+// picked 选择多次，返回被选中过的节点id
+func picked(h SchedulingHandler, nodes []*models.ServiceNode) map[string]bool {
+	rs := make(map[string]bool)
+	for i := 0; i < len(nodes)*4; i++ {
+		if n := h.GetServiceNode(nodes, "svc"); n != nil {
+			rs[n.Id] = true
+		}
+	}
+	return rs
+}
+
+func TestZoneAware(t *testing.T) {
+	nodes := []*models.ServiceNode{
+		zoned("a1", "east", "a"),
+		zoned("a2", "east", "a"),
+		zoned("b1", "east", "b"),
+		zoned("w1", "west", "a"), //其他地域的同名可用区
+	}
+	tests := []struct {
+		name         string
+		region, zone string
+		disabled     []int
+		threshold    float64
+		want         []string
+	}{
+		{"same zone", "east", "a", nil, 0, []string{"a1", "a2"}},
+		{"zone down spills to region", "east", "a", []int{0, 1}, 0, []string{"b1"}},
+		{"region down spills to all", "east", "a", []int{0, 1, 2}, 0, []string{"w1"}},
+		{"below threshold spills to region", "east", "a", []int{0}, 0.6, []string{"a2", "b1"}},
+		{"at threshold stays in zone", "east", "a", []int{0}, 0.5, []string{"a2"}},
+		{"region below threshold spills to all", "east", "a", []int{0, 2}, 0.6, []string{"a2", "w1"}},
+		{"no region compares zone only", "", "a", nil, 0, []string{"a1", "a2", "w1"}},
+		{"unknown zone uses region", "east", "c", nil, 0, []string{"a1", "a2", "b1"}},
+		{"no locality uses all", "", "", nil, 0, []string{"a1", "a2", "b1", "w1"}},
+	}
+	for _, tt := range tests {
+		for i, n := range nodes {
+			n.SetEnable(true)
+			for _, d := range tt.disabled {
+				if d == i {
+					n.SetEnable(false)
+				}
+			}
+		}
+		h := NewZoneAwareHandler(impl.NewRoundRobinHandler(), tt.region, tt.zone, tt.threshold)
+		got := picked(h, nodes)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: picked %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for _, id := range tt.want {
+			if !got[id] {
+				t.Errorf("%s: picked %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package selector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Selector 节点选择表达式，多个条件以逗号分隔且需同时满足，例如 "env=prod,version in (v2,v3),!canary"
+// 支持的条件：
+//
+//	key=value / key==value  值相等
+//	key!=value              值不相等(键不存在也满足)
+//	key in (a,b)            值在列表中
+//	key notin (a,b)         值不在列表中(键不存在也满足)
+//	key                     键存在
+//	!key                    键不存在
+//
+// 键先在节点元数据中查找，找不到时与节点标签比较，标签只有键没有值
+type Selector []requirement
+
+// Labels 可被选择表达式匹配的对象，如服务节点
+type Labels interface {
+	Label(key string) (string, bool) //按键查找元数据
+	HasTag(tag string) bool
+}
+
+type operator int
+
+const (
+	opEquals operator = iota
+	opNotEquals
+	opIn
+	opNotIn
+	opExists
+	opNotExists
+	opTag
+)
+
+type requirement struct {
+	key    string
+	op     operator
+	values []string
+}
+
+// Parse 解析选择表达式，空表达式匹配所有节点
+func Parse(expr string) (Selector, error) {
+	var sel Selector
+	for _, part := range split(expr) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		r, err := parseRequirement(part)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// split 按不在括号内的逗号拆分
+func split(expr string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, ch := range expr {
+		switch ch {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, expr[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, expr[start:])
+}
+
+// 键中不能出现的字符，避免 "!env=prod" 等写错的条件被当成键名而静默地匹配不到节点
+const keyInvalid = "!=(), \t"
+
+// 值中不能出现的字符
+const valueInvalid = "=(), \t"
+
+func checkKey(s, key string) error {
+	if key == "" {
+		return fmt.Errorf("selector %q: missing key", s)
+	}
+	if strings.ContainsAny(key, keyInvalid) {
+		return fmt.Errorf("selector %q: invalid key %q", s, key)
+	}
+	return nil
+}
+
+func checkValue(s, value string) error {
+	if strings.ContainsAny(value, valueInvalid) {
+		return fmt.Errorf("selector %q: invalid value %q", s, value)
+	}
+	return nil
+}
+
+func parseRequirement(s string) (requirement, error) {
+	if strings.HasPrefix(s, "!") && !strings.Contains(s, "=") {
+		key := strings.TrimSpace(s[1:])
+		if err := checkKey(s, key); err != nil {
+			return requirement{}, err
+		}
+		return requirement{key: key, op: opNotExists}, nil
+	}
+	fields := strings.Fields(s)
+	if len(fields) >= 2 && (fields[1] == "in" || fields[1] == "notin") {
+		op := opIn
+		if fields[1] == "notin" {
+			op = opNotIn
+		}
+		list := strings.TrimSpace(strings.Join(fields[2:], " "))
+		if !strings.HasPrefix(list, "(") || !strings.HasSuffix(list, ")") {
+			return requirement{}, fmt.Errorf("selector %q: values must be in parentheses", s)
+		}
+		if err := checkKey(s, fields[0]); err != nil {
+			return requirement{}, err
+		}
+		var values []string
+		for _, v := range strings.Split(list[1:len(list)-1], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				if err := checkValue(s, v); err != nil {
+					return requirement{}, err
+				}
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return requirement{}, fmt.Errorf("selector %q: empty value list", s)
+		}
+		return requirement{key: fields[0], op: op, values: values}, nil
+	}
+	for _, o := range []struct {
+		sep string
+		op  operator
+	}{{"!=", opNotEquals}, {"==", opEquals}, {"=", opEquals}} {
+		if i := strings.Index(s, o.sep); i >= 0 {
+			key, value := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+len(o.sep):])
+			if err := checkKey(s, key); err != nil {
+				return requirement{}, err
+			}
+			if err := checkValue(s, value); err != nil {
+				return requirement{}, err
+			}
+			return requirement{key: key, op: o.op, values: []string{value}}, nil
+		}
+	}
+	if len(fields) != 1 || strings.ContainsAny(fields[0], keyInvalid) {
+		return requirement{}, fmt.Errorf("selector %q: invalid requirement", s)
+	}
+	return requirement{key: fields[0], op: opExists}, nil
+}
+
+// Tag 只匹配带有指定标签的节点，tag为空时匹配所有节点
+func Tag(tag string) Selector {
+	if tag == "" {
+		return nil
+	}
+	return Selector{{key: tag, op: opTag}}
+}
+
+// FromMap 每个键值都需相等，如路由提示
+func FromMap(md map[string]string) Selector {
+	sel := make(Selector, 0, len(md))
+	for k, v := range md {
+		sel = append(sel, requirement{key: k, op: opEquals, values: []string{v}})
+	}
+	return sel
+}
+
+// Match 节点是否满足全部条件
+func (sel Selector) Match(n Labels) bool {
+	for _, r := range sel {
+		if !r.match(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter 返回满足条件的节点，没有条件时原样返回
+func Filter[T Labels](sel Selector, nodes []T) []T {
+	if len(sel) == 0 {
+		return nodes
+	}
+	rs := make([]T, 0, len(nodes))
+	for _, n := range nodes {
+		if sel.Match(n) {
+			rs = append(rs, n)
+		}
+	}
+	return rs
+}
+
+func (r requirement) match(n Labels) bool {
+	val, ok := lookup(n, r.key)
+	switch r.op {
+	case opEquals:
+		return ok && val == r.values[0]
+	case opNotEquals:
+		return !ok || val != r.values[0]
+	case opIn:
+		return ok && contains(r.values, val)
+	case opNotIn:
+		return !ok || !contains(r.values, val)
+	case opExists:
+		return ok
+	case opNotExists:
+		return !ok
+	case opTag:
+		return n.HasTag(r.key)
+	}
+	return false
+}
+
+func lookup(n Labels, key string) (string, bool) {
+	if v, ok := n.Label(key); ok {
+		return v, true
+	}
+	if n.HasTag(key) {
+		return "", true
+	}
+	return "", false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package selector
+
+import "testing"
+
+type node struct {
+	meta map[string]string
+	tags []string
+}
+
+func (n node) Label(key string) (string, bool) {
+	v, ok := n.meta[key]
+	return v, ok
+}
+
+func (n node) HasTag(tag string) bool {
+	for _, t := range n.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMatch(t *testing.T) {
+	prod := node{meta: map[string]string{"env": "prod", "version": "v2"}, tags: []string{"canary"}}
+	dev := node{meta: map[string]string{"env": "dev"}}
+	tests := []struct {
+		expr      string
+		prod, dev bool
+	}{
+		{"", true, true},
+		{"env=prod", true, false},
+		{"env==prod", true, false},
+		{" env = prod ", true, false},
+		{"env!=prod", false, true},
+		{"version!=v2", false, true}, //键不存在也满足
+		{"version in (v2,v3)", true, false},
+		{"version in ( v1 , v2 )", true, false},
+		{"version notin (v2,v3)", false, true},
+		{"env notin (test)", true, true},
+		{"version", true, false},
+		{"!version", false, true},
+		{"canary", true, false}, //元数据中没有时与标签比较
+		{"!canary", false, true},
+		{"canary=", true, false}, //标签只有键没有值
+		{"env=prod,version in (v2),!beta", true, false},
+		{"env=prod,canary,!version", false, false},
+		{"env in (prod,dev),version notin (v3)", true, true},
+	}
+	for _, tt := range tests {
+		sel, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := sel.Match(prod); got != tt.prod {
+			t.Errorf("%q matches prod = %v, want %v", tt.expr, got, tt.prod)
+		}
+		if got := sel.Match(dev); got != tt.dev {
+			t.Errorf("%q matches dev = %v, want %v", tt.expr, got, tt.dev)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"!env=prod",
+		"!",
+		"=prod",
+		"!=prod",
+		"env in v1",
+		"env in (v1",
+		"env in ()",
+		"env notin (,)",
+		"in (v1)",
+		"env in (a b)",
+		"env prod",
+		"env=prod)",
+		"env=a=b",
+		"env=(prod)",
+		"(env)",
+		"env=prod,!,version",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) should fail", expr)
+		}
+	}
+}
+
+func TestTagAndFromMap(t *testing.T) {
+	n := node{meta: map[string]string{"env": "prod"}, tags: []string{"canary"}}
+	if !Tag("").Match(n) || !Tag("canary").Match(n) || Tag("env").Match(n) {
+		t.Error("Tag should only match tags, empty tag matches all")
+	}
+	if !FromMap(map[string]string{"env": "prod"}).Match(n) || FromMap(map[string]string{"env": "prod", "zone": "a"}).Match(n) {
+		t.Error("FromMap should require every key to be equal")
+	}
+	nodes := []node{n, {meta: map[string]string{"env": "dev"}}}
+	if got := Filter(FromMap(map[string]string{"env": "dev"}), nodes); len(got) != 1 || got[0].meta["env"] != "dev" {
+		t.Errorf("Filter = %v", got)
+	}
+	if got := Filter(nil, nodes); len(got) != 2 {
+		t.Errorf("Filter without requirements = %v, want all nodes", got)
+	}
+}